@@ -1,12 +1,13 @@
 package stats
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"math"
 	"os"
-	"text/template"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
@@ -23,7 +24,7 @@ func (a *nullAdapter) Sample(c *docker.Container, n string, v uint64) {}
 
 // LogAdapter is a drain that drains the metrics to stdout in l2met format.
 type LogAdapter struct {
-	template *template.Template
+	template *safeTemplate
 	writer   io.Writer
 }
 
@@ -38,7 +39,9 @@ func NewLogAdapter(tmpl string, w io.Writer) (*LogAdapter, error) {
 		w = os.Stdout
 	}
 
-	t, err := template.New("stat").Parse(tmpl)
+	t, err := newSafeTemplate("stat", tmpl, L2MetTemplate, func() Sanitizer {
+		return unsafeRune
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -64,49 +67,250 @@ func (a *LogAdapter) write(c *docker.Container, typ, name string, value uint64)
 		Name:      name,
 		Value:     value,
 	}
-	fmt.Fprintln(a.writer, renderTemplate(a.template, data))
+	fmt.Fprintln(a.writer, a.template.render(data))
 }
 
 // StatsdTemplate defines the template used to render the statsd metric name.
 var StatsdTemplate = `{{.Name}}.source__{{.Container.Name}}.{{.Hostname}}__`
 
+// StatsdClient is the interface of a statsd client that's used by the
+// StatsdAdapter. It's satisfied by StatsdConn and the statsd client in
+// github.com/quipo/statsd.
 type StatsdClient interface {
 	Incr(name string, value int64) error
 	Gauge(name string, value int64) error
+	FGauge(name string, value float64) error
+	Timing(name string, delta int64) error
+	PrecisionTiming(name string, delta time.Duration) error
+	Absolute(name string, value int64) error
+	Total(name string, value int64) error
 }
 
+// maxStatsdValue bounds the values that fit in the int64 that statsd clients
+// take. Unlike math.MaxInt64, which rounds up to it as a float64, it's exact
+// for both uint64 and float64 values.
+const maxStatsdValue = 1 << 63
+
+// StatsdAdapter is an Adapter, AdapterV2 and BatchAdapter that sends metrics to
+// statsd. The statsd metric type is chosen by the kind of the metric: gauges
+// and rates are sent as gauges, counters are sent as statsd counters of the
+// change since the last value, events are sent as counters and timings as
+// timers.
 type StatsdAdapter struct {
+	// Labels and Env are lists of docker labels and environment variables
+	// that are sent as tags with every metric, when tags are enabled.
+	Labels []string
+	Env    []string
+
+	// Sanitizer is applied to every rendered metric name and tag. The
+	// default is StatsdSanitizer.
+	Sanitizer Sanitizer
+
 	client   StatsdClient
-	template *template.Template
+	template *safeTemplate
+	tags     *safeTemplate
+
+	mu sync.Mutex
+	// counters holds the last value of each counter, by container ID.
+	counters map[string]map[string]float64
 }
 
 func NewStatsdAdapter(c StatsdClient, tmpl string) (*StatsdAdapter, error) {
+	return newStatsdAdapter(c, tmpl, StatsdTemplate)
+}
+
+// newStatsdAdapter returns a new StatsdAdapter that renders metric names with
+// tmpl, which falls back to def.
+func newStatsdAdapter(c StatsdClient, tmpl, def string) (*StatsdAdapter, error) {
 	if tmpl == "" {
-		tmpl = StatsdTemplate
+		tmpl = def
+	}
+
+	a := &StatsdAdapter{
+		Sanitizer: StatsdSanitizer,
+		client:    c,
+		counters:  make(map[string]map[string]float64),
 	}
 
-	t, err := template.New("stat").Parse(tmpl)
+	t, err := a.parse("stat", tmpl, def)
 	if err != nil {
 		return nil, err
 	}
+	a.template = t
 
-	return &StatsdAdapter{
-		client:   c,
-		template: t,
-	}, nil
+	return a, nil
+}
+
+// NewDogStatsdAdapter returns a StatsdAdapter that sends the container as
+// DogStatsD tags instead of encoding it in the metric name. The tags template is
+// rendered as a comma separated list of `key:value` tags.
+func NewDogStatsdAdapter(c TaggedStatsdClient, tmpl, tags string) (*StatsdAdapter, error) {
+	if tags == "" {
+		tags = DogStatsdTagsTemplate
+	}
+
+	a, err := newStatsdAdapter(c, tmpl, DogStatsdTemplate)
+	if err != nil {
+		return nil, err
+	}
 
+	a.tags, err = a.parse("tags", tags, DogStatsdTagsTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Containers can only override the template of the metric name.
+	a.tags.label = ""
+
+	return a, nil
 }
 
 func (a *StatsdAdapter) Incr(c *docker.Container, name string, value uint64) {
-	if value <= math.MaxInt64 {
-		a.client.Incr(a.name(c, name), int64(value))
+	if value < maxStatsdValue {
+		a.clientFor(c).Incr(a.name(c, name), int64(value))
 	}
 }
 
 func (a *StatsdAdapter) Sample(c *docker.Container, name string, value uint64) {
-	if value <= math.MaxInt64 {
-		a.client.Gauge(a.name(c, name), int64(value))
+	if value < maxStatsdValue {
+		a.clientFor(c).Gauge(a.name(c, name), int64(value))
+	}
+}
+
+func (a *StatsdAdapter) Emit(m *Metric) {
+	if math.IsNaN(m.Value) || m.Value < 0 {
+		return
+	}
+
+	c := m.Container
+
+	switch m.Kind {
+	case Event:
+		if m.Value < maxStatsdValue {
+			a.clientFor(c).Incr(a.name(c, m.Name), int64(m.Value))
+		}
+	case Counter:
+		if delta, ok := a.delta(c.ID, m.Name, m.Value); ok && delta < maxStatsdValue {
+			a.clientFor(c).Incr(a.name(c, m.Name), int64(delta))
+		}
+	case Timing:
+		a.clientFor(c).PrecisionTiming(a.name(c, m.Name), time.Duration(m.Value))
+	default:
+		if m.Value == math.Trunc(m.Value) && m.Value < maxStatsdValue {
+			a.clientFor(c).Gauge(a.name(c, m.Name), int64(m.Value))
+		} else {
+			a.clientFor(c).FGauge(a.name(c, m.Name), m.Value)
+		}
+	}
+}
+
+// Batch sends each of the metrics.
+func (a *StatsdAdapter) Batch(metrics []*Metric) {
+	for _, m := range metrics {
+		a.Emit(m)
+	}
+}
+
+// Flush flushes the client, if it buffers metrics.
+func (a *StatsdAdapter) Flush() error {
+	if f, ok := a.client.(interface {
+		Flush() error
+	}); ok {
+		return f.Flush()
 	}
+	return nil
+}
+
+// delta records the latest value of a counter and returns the change since its
+// previous value. It returns false the first time a counter is seen. If the
+// counter was reset, the change is its new value.
+func (a *StatsdAdapter) delta(containerID, name string, value float64) (float64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.counters == nil {
+		a.counters = make(map[string]map[string]float64)
+	}
+
+	counters, ok := a.counters[containerID]
+	if !ok {
+		counters = make(map[string]float64)
+		a.counters[containerID] = counters
+	}
+
+	prev, ok := counters[name]
+	counters[name] = value
+
+	if !ok {
+		return 0, false
+	}
+
+	if value < prev {
+		return value, true
+	}
+
+	return value - prev, true
+}
+
+// Forget removes the counters of a container.
+func (a *StatsdAdapter) Forget(containerID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.counters, containerID)
+}
+
+// clientFor returns the client to send metrics for the container with, which
+// includes the container's tags when tags are enabled.
+func (a *StatsdAdapter) clientFor(c *docker.Container) StatsdClient {
+	t, ok := a.client.(TaggedStatsdClient)
+	if a.tags == nil || !ok {
+		return a.client
+	}
+
+	return t.WithTags(a.tagsFor(c))
+}
+
+// tagsFor renders the tags for the container.
+func (a *StatsdAdapter) tagsFor(c *docker.Container) []string {
+	var tags []string
+
+	// The pieces that are rendered into the tags are sanitized, so that
+	// they can't add tags by containing a `,`.
+	data := stat{
+		Container: escapeContainer(c, a.Sanitizer.Sanitize),
+		escape:    a.Sanitizer.Sanitize,
+	}
+	for _, tag := range strings.Split(a.tags.render(data), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, a.tag(tag))
+		}
+	}
+
+	if c.Config != nil {
+		for _, l := range a.Labels {
+			if v, ok := c.Config.Labels[l]; ok {
+				tags = append(tags, a.tag(l+":"+v))
+			}
+		}
+
+		for _, e := range a.Env {
+			if v := (stat{Container: c}).Env(e); v != "" {
+				tags = append(tags, a.tag(e+":"+v))
+			}
+		}
+	}
+
+	return tags
+}
+
+// tag sanitizes the key and value of a `key:value` tag.
+func (a *StatsdAdapter) tag(tag string) string {
+	parts := strings.SplitN(tag, ":", 2)
+	for i := range parts {
+		parts[i] = a.Sanitizer.Sanitize(parts[i])
+	}
+	return strings.Join(parts, ":")
 }
 
 func (a *StatsdAdapter) name(c *docker.Container, name string) string {
@@ -114,13 +318,13 @@ func (a *StatsdAdapter) name(c *docker.Container, name string) string {
 		Container: c,
 		Name:      name,
 	}
-	return renderTemplate(a.template, data)
+	return a.Sanitizer.Sanitize(a.template.render(data))
 }
 
-func renderTemplate(t *template.Template, data stat) string {
-	b := new(bytes.Buffer)
-	if err := t.Execute(b, data); err != nil {
-		panic(err)
-	}
-	return b.String()
+// parse parses a template, which falls back to def, with the template
+// functions. The sanitize function uses the Sanitizer of the adapter.
+func (a *StatsdAdapter) parse(name, tmpl, def string) (*safeTemplate, error) {
+	return newSafeTemplate(name, tmpl, def, func() Sanitizer {
+		return a.Sanitizer
+	})
 }
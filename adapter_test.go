@@ -3,7 +3,10 @@ package stats_test
 import (
 	"bytes"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/remind101/dockerstats"
@@ -38,8 +41,32 @@ func (c *fakeStatsdClient) Gauge(name string, value int64) error {
 	return c.write(name, value, "g")
 }
 
+func (c *fakeStatsdClient) FGauge(name string, value float64) error {
+	return c.writef(name, value, "g")
+}
+
+func (c *fakeStatsdClient) Timing(name string, delta int64) error {
+	return c.write(name, delta, "ms")
+}
+
+func (c *fakeStatsdClient) PrecisionTiming(name string, delta time.Duration) error {
+	return c.writef(name, fmt.Sprintf("%.6f", float64(delta)/float64(time.Millisecond)), "ms")
+}
+
+func (c *fakeStatsdClient) Absolute(name string, value int64) error {
+	return c.write(name, value, "a")
+}
+
+func (c *fakeStatsdClient) Total(name string, value int64) error {
+	return c.write(name, value, "t")
+}
+
 func (c *fakeStatsdClient) write(name string, value int64, typ string) error {
-	c.Stats = append(c.Stats, fmt.Sprintf("%s:%d|%s", name, value, typ))
+	return c.writef(name, value, typ)
+}
+
+func (c *fakeStatsdClient) writef(name string, value interface{}, typ string) error {
+	c.Stats = append(c.Stats, fmt.Sprintf("%s:%v|%s", name, value, typ))
 	return nil
 }
 
@@ -62,3 +89,128 @@ func TestStatsdAdapter(t *testing.T) {
 		t.Errorf("Incr() => %q; want %q", got, want)
 	}
 }
+
+func TestStatsdAdapter_Emit(t *testing.T) {
+	client := &fakeStatsdClient{Stats: []string{}}
+	a, err := stats.NewStatsdAdapter(client, `{{.Name}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &docker.Container{ID: "abcd", Name: "dummy"}
+
+	for _, m := range []*stats.Metric{
+		{Container: c, Name: "MemoryStats.Usage", Value: 1024, Kind: stats.Gauge},
+		{Container: c, Name: "CPUStats.Percent", Value: 12.5, Kind: stats.Gauge},
+		{Container: c, Name: "Network.RxBytes", Value: 100, Kind: stats.Counter},
+		{Container: c, Name: "Network.RxBytes", Value: 150, Kind: stats.Counter},
+		{Container: c, Name: "Network.RxBytes", Value: 20, Kind: stats.Counter},
+		{Container: c, Name: "Container.Uptime", Value: float64(1500 * time.Millisecond), Kind: stats.Timing},
+		{Container: c, Name: "Container.Start", Value: 1, Kind: stats.Event},
+
+		// Values that don't fit in an int64 are sent as float gauges,
+		// or dropped when they can only be sent as integers.
+		{Container: c, Name: "MemoryStats.Limit", Value: 1 << 63, Kind: stats.Gauge},
+		{Container: c, Name: "Container.Start", Value: 1 << 63, Kind: stats.Event},
+	} {
+		a.Emit(m)
+	}
+
+	want := []string{
+		"MemoryStats.Usage:1024|g",
+		"CPUStats.Percent:12.5|g",
+		"Network.RxBytes:50|c",
+		"Network.RxBytes:20|c",
+		"Container.Uptime:1500.000000|ms",
+		"Container.Start:1|c",
+		"MemoryStats.Limit:9.223372036854776e+18|g",
+	}
+	if got := client.Stats; !reflect.DeepEqual(got, want) {
+		t.Errorf("Emit() => %q; want %q", got, want)
+	}
+}
+
+type fakeTaggedStatsdClient struct {
+	*fakeStatsdClient
+	tags []string
+}
+
+func (c *fakeTaggedStatsdClient) WithTags(tags []string) stats.StatsdClient {
+	return &fakeTaggedStatsdClient{fakeStatsdClient: c.fakeStatsdClient, tags: tags}
+}
+
+func (c *fakeTaggedStatsdClient) Gauge(name string, value int64) error {
+	return c.fakeStatsdClient.Gauge(name+"|#"+strings.Join(c.tags, ","), value)
+}
+
+func TestDogStatsdAdapter(t *testing.T) {
+	client := &fakeTaggedStatsdClient{fakeStatsdClient: &fakeStatsdClient{Stats: []string{}}}
+	a, err := stats.NewDogStatsdAdapter(client, "", `container_name:{{.Container.Name}},image:{{.Image}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Labels = []string{"com.docker.compose.service"}
+	a.Env = []string{"SOURCE"}
+
+	c := &docker.Container{
+		Name: "web,1",
+		Config: &docker.Config{
+			Image:  "remind101/acme-inc",
+			Env:    []string{"SOURCE=dockerstats"},
+			Labels: map[string]string{"com.docker.compose.service": "web"},
+		},
+	}
+
+	a.Sample(c, "MemoryStats.Usage", 1024)
+	want := "docker.MemoryStats.Usage|#container_name:web_1,image:remind101_acme-inc,com.docker.compose.service:web,SOURCE:dockerstats:1024|g"
+	if got := client.Stats[0]; got != want {
+		t.Errorf("Sample() => %q; want %q", got, want)
+	}
+}
+
+func TestAsV2(t *testing.T) {
+	b := new(bytes.Buffer)
+	l, err := stats.NewLogAdapter(`{{.Type}}#{{.Name}}={{.Value}}`, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := stats.AsV2(l)
+	c := &docker.Container{Name: "dummy"}
+
+	a.Emit(&stats.Metric{Container: c, Name: "CPUStats.Percent", Value: 12.6, Kind: stats.Gauge})
+	a.Emit(&stats.Metric{Container: c, Name: "Container.Start", Value: 1, Kind: stats.Event})
+	a.Emit(&stats.Metric{Container: c, Name: "Invalid", Value: -1, Kind: stats.Gauge})
+
+	if got, want := b.String(), "sample#CPUStats.Percent=13\ncount#Container.Start=1\n"; got != want {
+		t.Errorf("Emit() => %q; want %q", got, want)
+	}
+}
+
+type fakeAdapterV2 struct {
+	Metrics []*stats.Metric
+}
+
+func (a *fakeAdapterV2) Emit(m *stats.Metric) {
+	a.Metrics = append(a.Metrics, m)
+}
+
+func TestAsV1(t *testing.T) {
+	f := &fakeAdapterV2{}
+	a := stats.AsV1(f)
+
+	a.Sample(&docker.Container{Name: "dummy"}, "MemoryStats.Usage", 1024)
+	if got, want := len(f.Metrics), 1; got != want {
+		t.Fatalf("len(Metrics) => %d; want %d", got, want)
+	}
+	if m := f.Metrics[0]; m.Name != "MemoryStats.Usage" || m.Value != 1024 || m.Kind != stats.Gauge {
+		t.Errorf("Sample() => %+v", m)
+	}
+
+	// Metrics should be passed through unchanged.
+	m := &stats.Metric{Name: "CPUStats.Percent", Value: 12.6, Kind: stats.Gauge}
+	stats.AsV2(a).Emit(m)
+	if got, want := f.Metrics[1], m; got != want {
+		t.Errorf("Emit() => %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// dockerAPI streams from the docker daemon's API without the docker client.
+//
+// The docker client's streams can't be closed: AddEventListener doesn't allow
+// the since parameter to be set, so a stream that's resumed after the daemon
+// restarts would miss events, and Stats blocks until the container is removed,
+// not just stopped. See eventStream and statsStream.
+type dockerAPI struct {
+	url    *url.URL
+	client *http.Client
+}
+
+// newDockerAPI returns a dockerAPI for the docker daemon at the endpoint, which
+// is either a unix socket, like unix:///var/run/docker.sock, or a tcp address,
+// like tcp://127.0.0.1:2376. tlsConfig is the TLS configuration of the docker
+// client, if any.
+func newDockerAPI(endpoint string, tlsConfig *tls.Config) (*dockerAPI, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.Dial = func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		}
+		u = &url.URL{Scheme: "http", Host: "docker"}
+	case "tcp", "http", "https":
+		u = &url.URL{Scheme: scheme, Host: u.Host}
+	default:
+		return nil, fmt.Errorf("invalid docker endpoint: %q", endpoint)
+	}
+
+	return &dockerAPI{
+		url:    u,
+		client: &http.Client{Transport: transport},
+	}, nil
+}
+
+// stream makes a GET request to the path, and returns the body of the
+// response, which is streamed until it's closed.
+func (a *dockerAPI) stream(path string, query url.Values) (io.ReadCloser, error) {
+	u := *a.url
+	u.Path = path
+	u.RawQuery = query.Encode()
+
+	resp, err := a.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status: %s", path, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// Stats connects to the stats API of the container. The samples are read from
+// the returned statsStream until the stream ends, or it's closed.
+func (a *dockerAPI) Stats(containerID string) (*statsStream, error) {
+	body, err := a.stream("/containers/"+containerID+"/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsStream{
+		body:    body,
+		decoder: json.NewDecoder(body),
+	}, nil
+}
+
+// statsStream decodes the stats samples from a connection to the stats API of
+// a container.
+type statsStream struct {
+	body    io.Closer
+	decoder *json.Decoder
+}
+
+// Next returns the next sample. It returns io.EOF when the daemon closes the
+// stream.
+func (s *statsStream) Next() (*docker.Stats, error) {
+	stats := new(docker.Stats)
+	if err := s.decoder.Decode(stats); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Close closes the connection, which stops the stream.
+func (s *statsStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,29 @@
+package stats
+
+import "testing"
+
+func TestNewDockerAPI(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		url      string
+	}{
+		{"unix:///var/run/docker.sock", "http://docker"},
+		{"tcp://127.0.0.1:2375", "http://127.0.0.1:2375"},
+		{"http://127.0.0.1:2375/", "http://127.0.0.1:2375"},
+	}
+
+	for _, tt := range tests {
+		a, err := newDockerAPI(tt.endpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := a.url.String(); got != tt.url {
+			t.Errorf("newDockerAPI(%q) => %s; want %s", tt.endpoint, got, tt.url)
+		}
+	}
+
+	if _, err := newDockerAPI("ftp://127.0.0.1", nil); err == nil {
+		t.Error("newDockerAPI(ftp) => nil; want an error")
+	}
+}
@@ -0,0 +1,58 @@
+package stats
+
+import "github.com/fsouza/go-dockerclient"
+
+// cpuUsage holds the cpu utilization of a container between two stats
+// samples, expressed as percentages.
+type cpuUsage struct {
+	// Percent is the utilization across all cores, the same value that
+	// `docker stats` shows. A container saturating 2 cores will report 200.
+	Percent float64
+
+	// UserPercent and KernelPercent are the parts of Percent that were spent
+	// in user and kernel mode.
+	UserPercent   float64
+	KernelPercent float64
+
+	// NormalizedPercent is Percent divided by the number of cores, so it's
+	// always between 0 and 100.
+	NormalizedPercent float64
+}
+
+// calculateCPU calculates the cpu utilization between the prev and cur stats
+// samples. It returns false if a percentage can't be calculated, which happens
+// when the cumulative counters went backwards (e.g. the container was
+// restarted and the counters were reset) or no system time elapsed.
+func calculateCPU(prev, cur *docker.Stats) (cpuUsage, bool) {
+	var u cpuUsage
+
+	if prev == nil || cur == nil {
+		return u, false
+	}
+
+	p, c := prev.CPUStats, cur.CPUStats
+
+	if c.CPUUsage.TotalUsage < p.CPUUsage.TotalUsage ||
+		c.CPUUsage.UsageInUsermode < p.CPUUsage.UsageInUsermode ||
+		c.CPUUsage.UsageInKernelmode < p.CPUUsage.UsageInKernelmode ||
+		c.SystemCPUUsage <= p.SystemCPUUsage {
+		return u, false
+	}
+
+	cpus := float64(len(c.CPUUsage.PercpuUsage))
+	if cpus == 0 {
+		cpus = 1
+	}
+
+	system := float64(c.SystemCPUUsage - p.SystemCPUUsage)
+	percent := func(cur, prev uint64) float64 {
+		return float64(cur-prev) / system * cpus * 100.0
+	}
+
+	u.Percent = percent(c.CPUUsage.TotalUsage, p.CPUUsage.TotalUsage)
+	u.UserPercent = percent(c.CPUUsage.UsageInUsermode, p.CPUUsage.UsageInUsermode)
+	u.KernelPercent = percent(c.CPUUsage.UsageInKernelmode, p.CPUUsage.UsageInKernelmode)
+	u.NormalizedPercent = u.Percent / cpus
+
+	return u, true
+}
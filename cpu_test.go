@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestCalculateCPU(t *testing.T) {
+	newStats := func(total, user, kernel, system uint64) *docker.Stats {
+		s := new(docker.Stats)
+		s.CPUStats.CPUUsage.PercpuUsage = []uint64{0, 0}
+		s.CPUStats.CPUUsage.TotalUsage = total
+		s.CPUStats.CPUUsage.UsageInUsermode = user
+		s.CPUStats.CPUUsage.UsageInKernelmode = kernel
+		s.CPUStats.SystemCPUUsage = system
+		return s
+	}
+
+	tests := []struct {
+		prev, cur *docker.Stats
+		usage     cpuUsage
+		ok        bool
+	}{
+		// No previous sample.
+		{nil, newStats(100, 50, 50, 1000), cpuUsage{}, false},
+
+		// Container used half of the system time on a 2 core host.
+		{
+			newStats(100, 50, 50, 1000),
+			newStats(600, 400, 200, 2000),
+			cpuUsage{Percent: 100, UserPercent: 70, KernelPercent: 30, NormalizedPercent: 50},
+			true,
+		},
+
+		// Counters were reset after a restart.
+		{newStats(600, 400, 200, 2000), newStats(100, 50, 50, 3000), cpuUsage{}, false},
+
+		// No system time elapsed.
+		{newStats(100, 50, 50, 1000), newStats(100, 50, 50, 1000), cpuUsage{}, false},
+	}
+
+	for i, tt := range tests {
+		usage, ok := calculateCPU(tt.prev, tt.cur)
+		if ok != tt.ok {
+			t.Errorf("#%d: ok => %v; want %v", i, ok, tt.ok)
+		}
+		if usage != tt.usage {
+			t.Errorf("#%d: usage => %+v; want %+v", i, usage, tt.usage)
+		}
+	}
+}
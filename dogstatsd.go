@@ -0,0 +1,18 @@
+package stats
+
+// DogStatsdTemplate defines the template used to render the metric name when
+// tags are used. The container is identified by tags, so the name is stable.
+var DogStatsdTemplate = `docker.{{.Name}}`
+
+// DogStatsdTagsTemplate defines the template used to render the DogStatsD tags,
+// as a comma separated list of `key:value` pairs.
+var DogStatsdTagsTemplate = `container_name:{{.Container.Name}},container_id:{{.ID}},image:{{.Image}},host:{{.Hostname}}`
+
+// TaggedStatsdClient is a StatsdClient that supports DogStatsD tags.
+type TaggedStatsdClient interface {
+	StatsdClient
+
+	// WithTags returns a StatsdClient that sends the given tags along with
+	// every metric.
+	WithTags(tags []string) StatsdClient
+}
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Events connects to the events API. When since is not 0, the events that
+// happened since that unix time are streamed first. The events are read from
+// the returned eventStream until the stream ends.
+func (a *dockerAPI) Events(since int64) (*eventStream, error) {
+	query := url.Values{}
+	if since != 0 {
+		query.Set("since", strconv.FormatInt(since, 10))
+	}
+
+	body, err := a.stream("/events", query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventStream{
+		body:    body,
+		decoder: json.NewDecoder(body),
+	}, nil
+}
+
+// dockerEvent is an event from the events API. docker.APIEvents doesn't decode
+// the attributes of the actor of the event, like the signal of a kill event,
+// which newer versions of the API include.
+type dockerEvent struct {
+	docker.APIEvents
+
+	Actor struct {
+		Attributes map[string]string
+	}
+
+	// stats is the latest stats sample that was received for the
+	// container when the event was handled. It's only set for oom
+	// events.
+	stats *docker.Stats
+}
+
+// eventStream decodes the events from a connection to the events API.
+type eventStream struct {
+	body    io.Closer
+	decoder *json.Decoder
+}
+
+// Next returns the next event. It returns io.EOF when the daemon closes the
+// stream.
+func (d *eventStream) Next() (*dockerEvent, error) {
+	for {
+		var event dockerEvent
+		if err := d.decoder.Decode(&event); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, err
+		}
+
+		// Messages that aren't events, like errors, don't have a time.
+		if event.Time == 0 {
+			continue
+		}
+
+		return &event, nil
+	}
+}
+
+// Close closes the connection.
+func (d *eventStream) Close() error {
+	return d.body.Close()
+}
+
+// eventCursor tracks the position in the event stream, so that it can be
+// resumed from the last event that was handled. The since parameter has a
+// resolution of a second, so the events of the last second are replayed when
+// the stream is resumed; the cursor counts them, so that only the replayed
+// ones are skipped, and events that happen to repeat within a second, like a
+// container that's crash looping, aren't.
+type eventCursor struct {
+	since int64
+
+	// seen counts the events of the since second that were handled.
+	seen map[string]int
+
+	// replay counts the events of the since second that are still to be
+	// replayed since the stream was resumed.
+	replay map[string]int
+}
+
+// Advance moves the cursor to the event. It returns false if the event was
+// replayed, and was already handled.
+func (c *eventCursor) Advance(event *docker.APIEvents) bool {
+	if event.Time > c.since {
+		c.since = event.Time
+		c.seen = nil
+		c.replay = nil
+	}
+
+	key := event.Status + "/" + event.ID + "/" + event.From
+	if c.replay[key] > 0 {
+		c.replay[key]--
+		return false
+	}
+
+	if c.seen == nil {
+		c.seen = make(map[string]int)
+	}
+	c.seen[key]++
+
+	return true
+}
+
+// Resume is called when the stream is resumed from since, so that the events
+// that are replayed are skipped.
+func (c *eventCursor) Resume() {
+	c.replay = make(map[string]int, len(c.seen))
+	for key, n := range c.seen {
+		c.replay[key] = n
+	}
+}
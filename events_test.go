@@ -0,0 +1,57 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestEventCursor(t *testing.T) {
+	c := &eventCursor{}
+
+	advance := func(events []*docker.APIEvents, want []bool) {
+		for i, e := range events {
+			if got := c.Advance(e); got != want[i] {
+				t.Errorf("Advance(%s %s %d) => %v; want %v", e.Status, e.ID, e.Time, got, want[i])
+			}
+		}
+	}
+
+	create := &docker.APIEvents{Status: "create", ID: "abcd", Time: 100}
+	start := &docker.APIEvents{Status: "start", ID: "abcd", Time: 100}
+	die := &docker.APIEvents{Status: "die", ID: "abcd", Time: 100}
+
+	// A container that's crash looping starts more than once within a
+	// second, which are all handled.
+	advance(
+		[]*docker.APIEvents{create, start, die, start},
+		[]bool{true, true, true, true},
+	)
+
+	// When the stream is resumed from 100, the events of that second are
+	// replayed, and skipped, but new ones aren't.
+	c.Resume()
+	advance(
+		[]*docker.APIEvents{create, start, die, start, die},
+		[]bool{false, false, false, false, true},
+	)
+
+	// Resuming again skips the new event too.
+	c.Resume()
+	advance(
+		[]*docker.APIEvents{create, start, die, start, die},
+		[]bool{false, false, false, false, false},
+	)
+
+	advance(
+		[]*docker.APIEvents{
+			{Status: "start", ID: "abcd", Time: 101},
+			{Status: "die", ID: "abcd", Time: 102},
+		},
+		[]bool{true, true},
+	)
+
+	if got, want := c.since, int64(102); got != want {
+		t.Errorf("since => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,247 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/mb0/glob"
+)
+
+// containerFilter matches containers by their name, image or labels. Filters
+// are parsed from strings of the form:
+//
+//	name=<glob>          The name of the container matches the glob.
+//	image=<glob>         The image of the container matches the glob.
+//	label=<key>          The container has the label.
+//	label=<key>=<value>  The container has the label, with the value.
+//
+// A filter that's prefixed with `!` matches containers that don't match the
+// rest of the filter.
+type containerFilter struct {
+	negate bool
+	match  func(c *docker.Container) bool
+}
+
+// parseContainerFilter parses a single filter.
+func parseContainerFilter(s string) (*containerFilter, error) {
+	f := &containerFilter{}
+
+	expr := s
+	if strings.HasPrefix(expr, "!") {
+		f.negate = true
+		expr = expr[1:]
+	}
+
+	parts := strings.SplitN(expr, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid container filter: %q", s)
+	}
+	field, value := parts[0], parts[1]
+
+	switch field {
+	case "name":
+		f.match = func(c *docker.Container) bool {
+			ok, _ := glob.Match(value, strings.TrimPrefix(c.Name, "/"))
+			return ok
+		}
+	case "image":
+		f.match = func(c *docker.Container) bool {
+			ok, _ := glob.Match(value, containerImage(c))
+			return ok
+		}
+	case "label":
+		kv := strings.SplitN(value, "=", 2)
+		f.match = func(c *docker.Container) bool {
+			if c.Config == nil {
+				return false
+			}
+
+			v, ok := c.Config.Labels[kv[0]]
+			if len(kv) == 1 {
+				return ok
+			}
+			return ok && v == kv[1]
+		}
+	default:
+		return nil, fmt.Errorf("invalid container filter: %q: unknown field %q", s, field)
+	}
+
+	return f, nil
+}
+
+// Match reports whether the container matches the filter.
+func (f *containerFilter) Match(c *docker.Container) bool {
+	return f.match(c) != f.negate
+}
+
+// containerFilters decides which containers are collected from.
+type containerFilters struct {
+	include []*containerFilter
+	exclude []*containerFilter
+}
+
+// newContainerFilters parses the include and exclude filters.
+func newContainerFilters(include, exclude []string) (*containerFilters, error) {
+	parse := func(filters []string) ([]*containerFilter, error) {
+		var parsed []*containerFilter
+		for _, s := range filters {
+			f, err := parseContainerFilter(s)
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, f)
+		}
+		return parsed, nil
+	}
+
+	var (
+		f   containerFilters
+		err error
+	)
+
+	if f.include, err = parse(include); err != nil {
+		return nil, err
+	}
+
+	if f.exclude, err = parse(exclude); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Match reports whether metrics and events should be collected from the
+// container. A container is collected from when it matches any of the include
+// filters, or there are none, and it doesn't match any of the exclude
+// filters.
+func (f *containerFilters) Match(c *docker.Container) bool {
+	for _, e := range f.exclude {
+		if e.Match(c) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, i := range f.include {
+		if i.Match(c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metricFilter decides which stats are drained, by name. A stat is drained
+// when it matches any of the whitelist patterns, or the whitelist is empty,
+// and doesn't match any of the blacklist patterns, so the blacklist takes
+// precedence. Derived stats are also matched by the name of the stat that
+// they're derived from. Patterns are globs, which can use `*` for wildcard matches, or
+// regular expressions when they're prefixed with `~`.
+//
+// The same names are checked for every container on every tick, so the
+// decision for each name is cached.
+type metricFilter struct {
+	whitelist []func(name string) bool
+	blacklist []func(name string) bool
+
+	mu    sync.Mutex
+	cache map[string]bool
+}
+
+// newMetricFilter parses the whitelist and blacklist patterns.
+func newMetricFilter(whitelist, blacklist []string) (*metricFilter, error) {
+	parse := func(patterns []string) ([]func(string) bool, error) {
+		var parsed []func(string) bool
+		for _, p := range patterns {
+			match, err := parsePattern(p)
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, match)
+		}
+		return parsed, nil
+	}
+
+	f := &metricFilter{cache: make(map[string]bool)}
+
+	var err error
+	if f.whitelist, err = parse(whitelist); err != nil {
+		return nil, err
+	}
+
+	if f.blacklist, err = parse(blacklist); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// parsePattern parses a glob, or a regular expression if it's prefixed with
+// `~`.
+func parsePattern(pattern string) (func(name string) bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %q: %v", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	return func(name string) bool {
+		ok, _ := glob.Match(pattern, name)
+		return ok
+	}, nil
+}
+
+// Match reports whether the stat should be drained.
+func (f *metricFilter) Match(name string) bool {
+	return f.MatchDerived(name, name)
+}
+
+// MatchDerived reports whether a stat that's derived from another, like
+// MemoryStats.Usage.max or Network.RxBytes.Rate, should be drained. It's
+// drained when either name is whitelisted, and neither is blacklisted, so that
+// whitelisting or blacklisting a stat also applies to the stats derived from
+// it.
+func (f *metricFilter) MatchDerived(name, base string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ok, cached := f.cache[name]; cached {
+		return ok
+	}
+
+	ok := f.match(name, base)
+	f.cache[name] = ok
+	return ok
+}
+
+func (f *metricFilter) match(names ...string) bool {
+	for _, match := range f.blacklist {
+		for _, name := range names {
+			if match(name) {
+				return false
+			}
+		}
+	}
+
+	if len(f.whitelist) == 0 {
+		return true
+	}
+
+	for _, match := range f.whitelist {
+		for _, name := range names {
+			if match(name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerFilters(t *testing.T) {
+	web := &docker.Container{
+		Name: "web.1",
+		Config: &docker.Config{
+			Image:  "remind101/acme-inc:latest",
+			Labels: map[string]string{"com.docker.compose.service": "web"},
+		},
+	}
+	oneoff := &docker.Container{
+		Name: "web_run_1",
+		Config: &docker.Config{
+			Image: "remind101/acme-inc:latest",
+			Labels: map[string]string{
+				"com.docker.compose.service": "web",
+				"com.docker.compose.oneoff":  "True",
+			},
+		},
+	}
+	build := &docker.Container{
+		Name:   "/fervent_turing",
+		Config: &docker.Config{Image: "golang:1.5"},
+	}
+	noConfig := &docker.Container{Name: "web.2"}
+
+	tests := []struct {
+		include, exclude []string
+		match            map[*docker.Container]bool
+	}{
+		{
+			nil, nil,
+			map[*docker.Container]bool{web: true, oneoff: true, build: true, noConfig: true},
+		},
+		{
+			[]string{"name=web*"}, nil,
+			map[*docker.Container]bool{web: true, oneoff: true, build: false, noConfig: true},
+		},
+		{
+			[]string{"image=remind101/*"}, nil,
+			map[*docker.Container]bool{web: true, oneoff: true, build: false, noConfig: false},
+		},
+		{
+			[]string{"label=com.docker.compose.service"}, []string{"label=com.docker.compose.oneoff=True"},
+			map[*docker.Container]bool{web: true, oneoff: false, build: false, noConfig: false},
+		},
+		{
+			[]string{"!name=fervent_turing"}, nil,
+			map[*docker.Container]bool{web: true, oneoff: true, build: false, noConfig: true},
+		},
+		{
+			nil, []string{"!label=com.docker.compose.service=web"},
+			map[*docker.Container]bool{web: true, oneoff: true, build: false, noConfig: false},
+		},
+		{
+			[]string{"name=fervent_*", "label=com.docker.compose.oneoff"}, nil,
+			map[*docker.Container]bool{web: false, oneoff: true, build: true, noConfig: false},
+		},
+	}
+
+	for _, tt := range tests {
+		f, err := newContainerFilters(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for c, want := range tt.match {
+			if got := f.Match(c); got != want {
+				t.Errorf("include=%q exclude=%q: Match(%s) => %v; want %v", tt.include, tt.exclude, c.Name, got, want)
+			}
+		}
+	}
+}
+
+func TestContainerFilters_Invalid(t *testing.T) {
+	for _, filter := range []string{
+		"web",
+		"name=",
+		"id=abcd",
+	} {
+		if _, err := newContainerFilters([]string{filter}, nil); err == nil {
+			t.Errorf("newContainerFilters(%q) => nil; want an error", filter)
+		}
+	}
+}
+
+func TestMetricFilter(t *testing.T) {
+	tests := []struct {
+		whitelist, blacklist []string
+		match                map[string]bool
+	}{
+		{
+			nil, nil,
+			map[string]bool{"MemoryStats.Usage": true, "CPUStats.CPUUsage.PercpuUsage.0": true},
+		},
+		{
+			[]string{"MemoryStats.*"}, nil,
+			map[string]bool{"MemoryStats.Usage": true, "Network.RxBytes": false},
+		},
+		{
+			nil, []string{`~^CPUStats\.CPUUsage\.PercpuUsage\.\d+$`, "MemoryStats.Stats.Total*"},
+			map[string]bool{
+				"CPUStats.CPUUsage.PercpuUsage.0":  false,
+				"CPUStats.CPUUsage.PercpuUsage.12": false,
+				"CPUStats.CPUUsage.TotalUsage":     true,
+				"MemoryStats.Stats.TotalRss":       false,
+				"MemoryStats.Stats.Rss":            true,
+			},
+		},
+		{
+			// The blacklist takes precedence over the whitelist.
+			[]string{"~^MemoryStats"}, []string{"MemoryStats.Limit"},
+			map[string]bool{"MemoryStats.Usage": true, "MemoryStats.Limit": false, "Network.RxBytes": false},
+		},
+	}
+
+	for _, tt := range tests {
+		f, err := newMetricFilter(tt.whitelist, tt.blacklist)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Check each name twice, so the cached decision is checked too.
+		for i := 0; i < 2; i++ {
+			for name, want := range tt.match {
+				if got := f.Match(name); got != want {
+					t.Errorf("whitelist=%q blacklist=%q: Match(%s) => %v; want %v", tt.whitelist, tt.blacklist, name, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestMetricFilter_MatchDerived(t *testing.T) {
+	tests := []struct {
+		whitelist, blacklist []string
+		name, base           string
+		match                bool
+	}{
+		{[]string{"MemoryStats.Usage"}, nil, "MemoryStats.Usage.max", "MemoryStats.Usage", true},
+		{[]string{"MemoryStats.Usage.max"}, nil, "MemoryStats.Usage.max", "MemoryStats.Usage", true},
+		{[]string{"MemoryStats.Usage.max"}, nil, "MemoryStats.Usage.min", "MemoryStats.Usage", false},
+		{[]string{"Network.RxBytes"}, []string{"*.Rate"}, "Network.RxBytes.Rate", "Network.RxBytes", false},
+		{nil, []string{"Network.RxBytes"}, "Network.RxBytes.Rate", "Network.RxBytes", false},
+	}
+
+	for _, tt := range tests {
+		f, err := newMetricFilter(tt.whitelist, tt.blacklist)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := f.MatchDerived(tt.name, tt.base); got != tt.match {
+			t.Errorf("whitelist=%q blacklist=%q: MatchDerived(%s, %s) => %v; want %v", tt.whitelist, tt.blacklist, tt.name, tt.base, got, tt.match)
+		}
+	}
+}
+
+func TestMetricFilter_Invalid(t *testing.T) {
+	if _, err := newMetricFilter(nil, []string{"~("}); err == nil {
+		t.Error("newMetricFilter() => nil; want an error")
+	}
+}
@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// GraphiteTemplate defines the template used to render the graphite metric path.
+var GraphiteTemplate = `docker.{{.Hostname}}.{{.Container.Name}}.{{.Name}}`
+
+// GraphiteBufferSize is the number of lines that the GraphiteAdapter will
+// buffer while it's unable to send them to carbon. Once the buffer is full, new
+// lines are dropped, and counted in Internal.Graphite.DroppedLines.
+var GraphiteBufferSize = 10000
+
+// GraphiteBackoff is how long the GraphiteAdapter waits before reconnecting
+// to carbon when it can't be connected to. The wait is doubled after each
+// failed attempt, up to MaxGraphiteBackoff.
+var (
+	GraphiteBackoff    = time.Second
+	MaxGraphiteBackoff = 30 * time.Second
+)
+
+// graphiteDroppedLines is the number of lines that were dropped because the
+// buffer was full.
+var graphiteDroppedLines = newInternalCounter("Internal.Graphite.DroppedLines")
+
+// GraphiteAdapter is an AdapterV2 that writes metrics to carbon using the
+// graphite plaintext protocol over a persistent TCP connection, reconnecting
+// when the connection is lost.
+type GraphiteAdapter struct {
+	// Sanitizer is applied to the rendered metric path. Pieces of the path
+	// that are derived from the container, like its name, are sanitized as
+	// a single component of the path. The default is GraphiteSanitizer.
+	Sanitizer Sanitizer
+
+	template *safeTemplate
+	addr     string
+	lines    chan string
+	dial     func(addr string) (net.Conn, error)
+}
+
+// NewGraphiteAdapter parses the template string as a text/template and returns
+// a new GraphiteAdapter that writes to the carbon server at addr.
+func NewGraphiteAdapter(addr, tmpl string) (*GraphiteAdapter, error) {
+	if tmpl == "" {
+		tmpl = GraphiteTemplate
+	}
+
+	a := &GraphiteAdapter{
+		Sanitizer: GraphiteSanitizer,
+		addr:      addr,
+		lines:     make(chan string, GraphiteBufferSize),
+		dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, 5*time.Second)
+		},
+	}
+
+	t, err := newSafeTemplate("stat", tmpl, GraphiteTemplate, func() Sanitizer {
+		return a.Sanitizer
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.template = t
+
+	go a.run()
+
+	return a, nil
+}
+
+// Emit queues the metric to be sent to carbon as a `path value timestamp` line.
+func (a *GraphiteAdapter) Emit(m *Metric) {
+	data := stat{
+		Container: escapeContainer(m.Container, a.Sanitizer.SanitizeComponent),
+		Type:      m.Kind.String(),
+		Name:      m.Name,
+		Value:     m.Value,
+		escape:    a.Sanitizer.SanitizeComponent,
+	}
+
+	line := fmt.Sprintf("%s %s %d\n",
+		a.Sanitizer.Sanitize(a.template.render(data)),
+		strconv.FormatFloat(m.Value, 'f', -1, 64),
+		m.Time.Unix(),
+	)
+
+	select {
+	case a.lines <- line:
+	default:
+		graphiteDroppedLines.Incr()
+	}
+}
+
+// run sends queued lines to carbon, reconnecting with a backoff when the
+// connection fails. The lines that were written to a connection that failed
+// before they were flushed are sent again on the next connection.
+func (a *GraphiteAdapter) run() {
+	var (
+		conn    net.Conn
+		w       *bufio.Writer
+		backoff = GraphiteBackoff
+
+		// pending holds the lines that haven't been flushed, the first
+		// written of which are in the buffer of w.
+		pending []string
+		written int
+	)
+
+	for line := range a.lines {
+		pending = append(pending, line)
+
+		for {
+			if conn == nil {
+				var err error
+				conn, err = a.dial(a.addr)
+				if err != nil {
+					debug("graphite: connect: err: %s", err)
+					time.Sleep(backoff)
+					if backoff *= 2; backoff > MaxGraphiteBackoff {
+						backoff = MaxGraphiteBackoff
+					}
+					continue
+				}
+				w = bufio.NewWriter(conn)
+				backoff = GraphiteBackoff
+				written = 0
+			}
+
+			err := func() error {
+				for ; written < len(pending); written++ {
+					l := pending[written]
+
+					// Flush before a line that doesn't fit in the
+					// buffer, rather than letting the writer flush
+					// part of it, so that it's known which lines
+					// were sent.
+					if w.Buffered() > 0 && w.Available() < len(l) {
+						if err := w.Flush(); err != nil {
+							return err
+						}
+						pending, written = pending[written:], 0
+					}
+
+					if _, err := w.WriteString(l); err != nil {
+						return err
+					}
+				}
+
+				// Flush once there's nothing else queued, so that
+				// lines are batched into as few writes as possible.
+				if len(a.lines) == 0 {
+					if err := w.Flush(); err != nil {
+						return err
+					}
+					pending, written = pending[:0], 0
+				}
+
+				return nil
+			}()
+			if err != nil {
+				debug("graphite: write: err: %s", err)
+				conn.Close()
+				conn = nil
+				continue
+			}
+
+			break
+		}
+	}
+}
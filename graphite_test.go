@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestGraphiteAdapter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	a, err := NewGraphiteAdapter(l.Addr().String(), `docker.{{.Container.Name}}.{{.Env "APP"}}.{{.Name}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &docker.Container{
+		Name: "web.1 copy",
+		Config: &docker.Config{
+			Env: []string{"APP=acme.inc"},
+		},
+	}
+
+	a.Emit(&Metric{Container: c, Name: "MemoryStats.Usage", Value: 1024, Time: time.Unix(1, 0)})
+	a.Emit(&Metric{Container: c, Name: "CPUStats.Percent", Value: 12.5, Time: time.Unix(2, 0)})
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	for _, want := range []string{
+		"docker.web_1_copy.acme_inc.MemoryStats.Usage 1024 1\n",
+		"docker.web_1_copy.acme_inc.CPUStats.Percent 12.5 2\n",
+	} {
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("line => %q; want %q", got, want)
+		}
+	}
+}
+
+// graphiteConn is a net.Conn that records what's written to it, until err is
+// set.
+type graphiteConn struct {
+	net.Conn
+	buf bytes.Buffer
+	err error
+}
+
+func (c *graphiteConn) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.buf.Write(b)
+}
+
+func (c *graphiteConn) Close() error {
+	return nil
+}
+
+func TestGraphiteAdapter_Reconnect(t *testing.T) {
+	backoff := GraphiteBackoff
+	GraphiteBackoff = time.Millisecond
+	defer func() { GraphiteBackoff = backoff }()
+
+	// The first connection fails when the lines are flushed, and the second
+	// can't be made.
+	conns := []*graphiteConn{
+		{err: errors.New("connection reset by peer")},
+		nil,
+		{},
+	}
+	var dials int
+	a := &GraphiteAdapter{
+		lines: make(chan string, 3),
+		dial: func(addr string) (net.Conn, error) {
+			c := conns[dials]
+			dials++
+			if c == nil {
+				return nil, errors.New("connection refused")
+			}
+			return c, nil
+		},
+	}
+
+	a.lines <- "a 1 1\n"
+	a.lines <- "b 2 1\n"
+	a.lines <- "c 3 1\n"
+	close(a.lines)
+	a.run()
+
+	// All of the lines that weren't flushed are sent on the next
+	// connection, not only the one that failed.
+	if got, want := conns[2].buf.String(), "a 1 1\nb 2 1\nc 3 1\n"; got != want {
+		t.Errorf("lines => %q; want %q", got, want)
+	}
+}
+
+func TestGraphiteAdapter_BufferFull(t *testing.T) {
+	tmpl, err := newSafeTemplate("stat", GraphiteTemplate, GraphiteTemplate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &GraphiteAdapter{
+		Sanitizer: GraphiteSanitizer,
+		template:  tmpl,
+		lines:     make(chan string, 1),
+	}
+
+	before := atomic.LoadUint64(&graphiteDroppedLines.value)
+
+	c := &docker.Container{Name: "web.1"}
+	a.Emit(&Metric{Container: c, Name: "MemoryStats.Usage", Value: 1024, Time: time.Unix(1, 0)})
+	a.Emit(&Metric{Container: c, Name: "MemoryStats.Usage", Value: 2048, Time: time.Unix(2, 0)})
+
+	if got, want := atomic.LoadUint64(&graphiteDroppedLines.value)-before, uint64(1); got != want {
+		t.Errorf("dropped => %d; want %d", got, want)
+	}
+}
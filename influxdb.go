@@ -0,0 +1,230 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InfluxDBUDPPayloadSize is the maximum size of the datagrams that are sent to
+// InfluxDB over UDP. Lines are never split across datagrams.
+var InfluxDBUDPPayloadSize = 512
+
+// InfluxDBTimeout is the timeout of writes to InfluxDB over HTTP, when the
+// adapter is created without an http.Client, so that a stalled server can't
+// block flushing.
+var InfluxDBTimeout = 10 * time.Second
+
+// InfluxDBMaxLines is the maximum number of lines that are buffered until the
+// adapter is flushed. When a write takes longer than the resolution, the
+// oldest lines are dropped, and counted in Internal.InfluxDB.DroppedLines.
+var InfluxDBMaxLines = 10000
+
+// influxDBDroppedLines is the number of lines that were dropped because the
+// buffer was full.
+var influxDBDroppedLines = newInternalCounter("Internal.InfluxDB.DroppedLines")
+
+// InfluxDBAdapter is an AdapterV2 and BatchAdapter that writes metrics to
+// InfluxDB using the line protocol. Each stat group (network, memory, cpu,
+// blkio) is written as a measurement, with a field for each value in the
+// group and tags for the container name, ID, image and host.
+//
+// Metrics are buffered and written when the adapter is flushed. At most
+// InfluxDBMaxLines are buffered.
+type InfluxDBAdapter struct {
+	write func(lines []string) error
+
+	mu    sync.Mutex
+	lines []string
+}
+
+// NewInfluxDBUDPAdapter returns an InfluxDBAdapter that writes to the UDP
+// listener of an InfluxDB server at addr.
+func NewInfluxDBUDPAdapter(addr string) (*InfluxDBAdapter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InfluxDBAdapter{
+		write: func(lines []string) error {
+			b := new(bytes.Buffer)
+			send := func() error {
+				if b.Len() == 0 {
+					return nil
+				}
+				_, err := conn.Write(b.Bytes())
+				b.Reset()
+				return err
+			}
+
+			for _, l := range lines {
+				if b.Len() > 0 && b.Len()+len(l)+1 > InfluxDBUDPPayloadSize {
+					if err := send(); err != nil {
+						return err
+					}
+				}
+				b.WriteString(l + "\n")
+			}
+
+			return send()
+		},
+	}, nil
+}
+
+// NewInfluxDBHTTPAdapter returns an InfluxDBAdapter that writes to the given
+// InfluxDB write endpoint (e.g. http://localhost:8086/write?db=docker). The
+// precision of the endpoint must be nanoseconds, which is the default. If
+// client is nil, a client with a timeout of InfluxDBTimeout is used.
+func NewInfluxDBHTTPAdapter(url string, client *http.Client) *InfluxDBAdapter {
+	if client == nil {
+		client = &http.Client{Timeout: InfluxDBTimeout}
+	}
+
+	return &InfluxDBAdapter{
+		write: func(lines []string) error {
+			body := strings.Join(lines, "\n") + "\n"
+			resp, err := client.Post(url, "text/plain; charset=utf-8", strings.NewReader(body))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode/100 != 2 {
+				body, _ := ioutil.ReadAll(resp.Body)
+				return fmt.Errorf("influxdb: unexpected response %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+			}
+
+			return nil
+		},
+	}
+}
+
+// Emit buffers a single metric.
+func (a *InfluxDBAdapter) Emit(m *Metric) {
+	a.Batch([]*Metric{m})
+}
+
+// Batch buffers the metrics, combining metrics from the same stat group into a
+// single line. If more than InfluxDBMaxLines are buffered, the oldest lines
+// are dropped.
+func (a *InfluxDBAdapter) Batch(metrics []*Metric) {
+	var (
+		lines []*influxLine
+		byKey = make(map[string]*influxLine)
+	)
+
+	for _, m := range metrics {
+		measurement, field := influxSplit(m.family())
+		series := influxKey(measurement, influxTags(m))
+		timestamp := strconv.FormatInt(m.Time.UnixNano(), 10)
+
+		key := series + " " + timestamp
+		l, ok := byKey[key]
+		if !ok {
+			l = &influxLine{series: series, timestamp: timestamp}
+			byKey[key] = l
+			lines = append(lines, l)
+		}
+
+		l.fields = append(l.fields, influxEscape.Replace(field)+"="+strconv.FormatFloat(m.Value, 'f', -1, 64))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, l := range lines {
+		a.lines = append(a.lines, l.String())
+	}
+
+	if n := len(a.lines) - InfluxDBMaxLines; n > 0 {
+		a.lines = append(a.lines[:0], a.lines[n:]...)
+		influxDBDroppedLines.Add(uint64(n))
+	}
+}
+
+// Flush writes all of the buffered lines.
+func (a *InfluxDBAdapter) Flush() error {
+	a.mu.Lock()
+	lines := a.lines
+	a.lines = nil
+	a.mu.Unlock()
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return a.write(lines)
+}
+
+// influxLine is a single point in the line protocol.
+type influxLine struct {
+	series    string
+	fields    []string
+	timestamp string
+}
+
+func (l *influxLine) String() string {
+	return l.series + " " + strings.Join(l.fields, ",") + " " + l.timestamp
+}
+
+// influxSplit splits a metric name into an InfluxDB measurement and field,
+// e.g. MemoryStats.Stats.Cache becomes measurement memory and field
+// Stats.Cache.
+func influxSplit(name string) (measurement, field string) {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) == 1 {
+		return "docker", name
+	}
+
+	measurement = strings.ToLower(strings.TrimSuffix(parts[0], "Stats"))
+	return measurement, parts[1]
+}
+
+// influxTags returns the tags for the metric.
+func influxTags(m *Metric) map[string]string {
+	tags := map[string]string{
+		"container_name": m.Container.Name,
+		"container_id":   m.Container.ID,
+		"image":          containerImage(m.Container),
+		"host":           hostname,
+	}
+	for k, v := range m.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// influxKey returns the escaped measurement and tag set, sorted by tag key as
+// recommended by InfluxDB. Tags with empty values are omitted.
+func influxKey(measurement string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	b := new(bytes.Buffer)
+	b.WriteString(influxMeasurementEscape.Replace(measurement))
+	for _, k := range keys {
+		b.WriteString("," + influxEscape.Replace(k) + "=" + influxEscape.Replace(tags[k]))
+	}
+	return b.String()
+}
+
+var (
+	// influxEscape escapes tag keys, tag values and field keys.
+	influxEscape = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+
+	// influxMeasurementEscape escapes measurements.
+	influxMeasurementEscape = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+)
@@ -0,0 +1,187 @@
+package stats_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/remind101/dockerstats"
+)
+
+var influxContainer = &docker.Container{
+	ID:     "abcd",
+	Name:   "web 1",
+	Config: &docker.Config{Image: "remind101/acme-inc"},
+}
+
+func emitInfluxMetrics(a *stats.InfluxDBAdapter) {
+	t := time.Unix(1, 5)
+	a.Batch([]*stats.Metric{
+		{Container: influxContainer, Name: "MemoryStats.Usage", Value: 1024, Time: t},
+		{Container: influxContainer, Name: "MemoryStats.Limit", Value: 2048, Time: t},
+		{
+			Container: influxContainer,
+			Name:      "BlkioStats.IOServiceBytesRecursive.8_0.Read",
+			Value:     512,
+			Time:      t,
+			Tags:      map[string]string{"device": "8:0", "op": "Read"},
+			Family:    "BlkioStats.IOServiceBytesRecursive",
+		},
+	})
+}
+
+func influxLines() string {
+	host, _ := os.Hostname()
+	tags := `container_id=abcd,container_name=web\ 1,host=` + host + `,image=remind101/acme-inc`
+	return `memory,` + tags + ` Usage=1024,Limit=2048 1000000005
+blkio,container_id=abcd,container_name=web\ 1,device=8:0,host=` + host + `,image=remind101/acme-inc,op=Read IOServiceBytesRecursive=512 1000000005
+`
+}
+
+func TestInfluxDBAdapter_HTTP(t *testing.T) {
+	var body string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Path, "/write"; got != want {
+			t.Errorf("Path => %q; want %q", got, want)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	a := stats.NewInfluxDBHTTPAdapter(s.URL+"/write?db=docker", nil)
+	emitInfluxMetrics(a)
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := body, influxLines(); got != want {
+		t.Errorf("body => %q; want %q", got, want)
+	}
+
+	// Nothing should be written when there's nothing buffered.
+	body = ""
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		t.Errorf("body => %q; want %q", body, "")
+	}
+}
+
+func TestInfluxDBAdapter_HTTPError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+	}))
+	defer s.Close()
+
+	a := stats.NewInfluxDBHTTPAdapter(s.URL+"/write?db=docker", nil)
+	emitInfluxMetrics(a)
+
+	if err := a.Flush(); err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("Flush() => %v; want an error", err)
+	}
+}
+
+func TestInfluxDBAdapter_HTTPTimeout(t *testing.T) {
+	// The server accepts the write, but never responds.
+	closed := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-closed:
+		}
+	}))
+	defer s.Close()
+	defer close(closed)
+
+	timeout := stats.InfluxDBTimeout
+	stats.InfluxDBTimeout = 50 * time.Millisecond
+	defer func() { stats.InfluxDBTimeout = timeout }()
+
+	a := stats.NewInfluxDBHTTPAdapter(s.URL+"/write?db=docker", nil)
+	emitInfluxMetrics(a)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Flush()
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("Flush() => nil; want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Flush to return")
+	}
+}
+
+func TestInfluxDBAdapter_MaxLines(t *testing.T) {
+	var body string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	max := stats.InfluxDBMaxLines
+	stats.InfluxDBMaxLines = 1
+	defer func() { stats.InfluxDBMaxLines = max }()
+
+	a := stats.NewInfluxDBHTTPAdapter(s.URL+"/write?db=docker", nil)
+	emitInfluxMetrics(a)
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the newest line is kept.
+	lines := strings.SplitAfter(influxLines(), "\n")
+	if got, want := body, lines[1]; got != want {
+		t.Errorf("body => %q; want %q", got, want)
+	}
+}
+
+func TestInfluxDBAdapter_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	a, err := stats.NewInfluxDBUDPAdapter(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitInfluxMetrics(a)
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	want := influxLines()
+	b := make([]byte, 65536)
+	for len(got) < len(want) {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += string(b[:n])
+	}
+
+	if got != want {
+		t.Errorf("datagrams => %q; want %q", got, want)
+	}
+}
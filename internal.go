@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// InternalContainer is the container that internal metrics about dockerstats
+// itself, like Internal.Statsd.SendFailures, are attributed to.
+var InternalContainer = &docker.Container{
+	ID:   "dockerstats-internal",
+	Name: "dockerstats",
+	Config: &docker.Config{
+		Image: "remind101/dockerstats",
+	},
+}
+
+// internalMetric is a metric about dockerstats itself. It's either a
+// cumulative counter or a gauge.
+type internalMetric struct {
+	name  string
+	kind  Kind
+	value uint64
+}
+
+// Incr increments the counter by 1.
+func (c *internalMetric) Incr() {
+	c.Add(1)
+}
+
+// Add increments the counter by n.
+func (c *internalMetric) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Set sets the value of the gauge.
+func (c *internalMetric) Set(n uint64) {
+	atomic.StoreUint64(&c.value, n)
+}
+
+var internalMetrics struct {
+	sync.Mutex
+	metrics []*internalMetric
+}
+
+// newInternalCounter registers a new internal counter.
+func newInternalCounter(name string) *internalMetric {
+	return newInternalMetric(name, Counter)
+}
+
+// newInternalGauge registers a new internal gauge.
+func newInternalGauge(name string) *internalMetric {
+	return newInternalMetric(name, Gauge)
+}
+
+func newInternalMetric(name string, kind Kind) *internalMetric {
+	internalMetrics.Lock()
+	defer internalMetrics.Unlock()
+
+	c := &internalMetric{name: name, kind: kind}
+	internalMetrics.metrics = append(internalMetrics.metrics, c)
+	return c
+}
+
+// walkInternal calls fn with a Metric for each internal metric. The Container
+// and Time of the metrics are left empty.
+func walkInternal(fn func(Metric)) {
+	internalMetrics.Lock()
+	metrics := internalMetrics.metrics
+	internalMetrics.Unlock()
+
+	for _, c := range metrics {
+		fn(Metric{
+			Name:  c.name,
+			Value: float64(atomic.LoadUint64(&c.value)),
+			Kind:  c.kind,
+			Unit:  UnitCount,
+		})
+	}
+}
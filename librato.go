@@ -0,0 +1,275 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LibratoURL is the default url of the Librato API.
+var LibratoURL = "https://metrics-api.librato.com"
+
+// LibratoSourceTemplate defines the template used to render the Librato source,
+// matching the source in L2MetTemplate.
+var LibratoSourceTemplate = `{{.Container.Name}}.{{.Hostname}}`
+
+// LibratoBatchSize is the maximum number of measurements that are sent to
+// Librato in a single request.
+var LibratoBatchSize = 300
+
+// LibratoAdapter is an AdapterV2 and BatchAdapter that sends metrics to the
+// Librato metrics API. Gauges and counters are buffered and sent when the
+// adapter is flushed, and events are sent as annotations.
+type LibratoAdapter struct {
+	// URL is the url of the Librato API. The zero value is LibratoURL.
+	URL string
+
+	// Client is the http.Client used to make requests. The zero value is
+	// http.DefaultClient.
+	Client *http.Client
+
+	// Retries is the number of times that a request is retried when
+	// Librato responds with a 5xx or 429, or the request fails.
+	Retries int
+
+	// Backoff is how long to wait before the first retry. It's doubled on
+	// each subsequent retry.
+	Backoff time.Duration
+
+	// Timeout bounds how long Flush spends sending, so that it doesn't
+	// delay the next tick. Requests that are still waiting for a response
+	// when it ends are canceled, and requests aren't retried if the
+	// backoff would end after it. The zero value is the DefaultResolution.
+	Timeout time.Duration
+
+	user, token string
+	template    *safeTemplate
+
+	mu          sync.Mutex
+	gauges      []libratoMeasurement
+	counters    []libratoMeasurement
+	annotations []libratoAnnotation
+}
+
+// NewLibratoAdapter parses the source template string as a text/template and
+// returns a new LibratoAdapter that authenticates with the given user and api
+// token.
+func NewLibratoAdapter(user, token, tmpl string) (*LibratoAdapter, error) {
+	if tmpl == "" {
+		tmpl = LibratoSourceTemplate
+	}
+
+	t, err := newSafeTemplate("stat", tmpl, LibratoSourceTemplate, func() Sanitizer {
+		return libratoSanitizer
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &LibratoAdapter{
+		Retries:  3,
+		Backoff:  time.Second,
+		user:     user,
+		token:    token,
+		template: t,
+	}, nil
+}
+
+type libratoMeasurement struct {
+	Name        string  `json:"name"`
+	Value       float64 `json:"value"`
+	Source      string  `json:"source,omitempty"`
+	MeasureTime int64   `json:"measure_time"`
+}
+
+type libratoAnnotation struct {
+	name string
+
+	Title     string `json:"title"`
+	Source    string `json:"source,omitempty"`
+	StartTime int64  `json:"start_time"`
+}
+
+// Emit buffers a single metric.
+func (a *LibratoAdapter) Emit(m *Metric) {
+	a.Batch([]*Metric{m})
+}
+
+// Batch buffers the metrics.
+func (a *LibratoAdapter) Batch(metrics []*Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, m := range metrics {
+		source := a.source(m)
+
+		switch m.Kind {
+		case Event:
+			a.annotations = append(a.annotations, libratoAnnotation{
+				name:      m.Name,
+				Title:     fmt.Sprintf("%s %s", m.Container.Name, m.Name),
+				Source:    source,
+				StartTime: m.Time.Unix(),
+			})
+		case Counter:
+			a.counters = append(a.counters, libratoMeasurement{
+				Name:        m.Name,
+				Value:       m.Value,
+				Source:      source,
+				MeasureTime: m.Time.Unix(),
+			})
+		default:
+			a.gauges = append(a.gauges, libratoMeasurement{
+				Name:        m.Name,
+				Value:       m.Value,
+				Source:      source,
+				MeasureTime: m.Time.Unix(),
+			})
+		}
+	}
+}
+
+// Flush sends all of the buffered metrics and annotations to Librato.
+func (a *LibratoAdapter) Flush() error {
+	a.mu.Lock()
+	gauges, counters, annotations := a.gauges, a.counters, a.annotations
+	a.gauges, a.counters, a.annotations = nil, nil, nil
+	a.mu.Unlock()
+
+	deadline := time.Now().Add(a.timeout())
+
+	var errs []string
+
+	for len(gauges) > 0 || len(counters) > 0 {
+		var body struct {
+			Gauges   []libratoMeasurement `json:"gauges,omitempty"`
+			Counters []libratoMeasurement `json:"counters,omitempty"`
+		}
+
+		n := len(gauges)
+		if n > LibratoBatchSize {
+			n = LibratoBatchSize
+		}
+		body.Gauges, gauges = gauges[:n], gauges[n:]
+
+		n = len(counters)
+		if n > LibratoBatchSize-len(body.Gauges) {
+			n = LibratoBatchSize - len(body.Gauges)
+		}
+		body.Counters, counters = counters[:n], counters[n:]
+
+		if err := a.post("/v1/metrics", body, deadline); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for _, an := range annotations {
+		if err := a.post("/v1/annotations/"+url.QueryEscape(an.name), an, deadline); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("librato: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// post posts v as json to the path, retrying on server errors until the
+// deadline.
+func (a *LibratoAdapter) post(path string, v interface{}, deadline time.Time) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	backoff := a.Backoff
+	for i := 0; ; i++ {
+		retry, err := a.do(path, body, deadline)
+		if err == nil || !retry || i >= a.Retries || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// timeout returns the Timeout, falling back to the DefaultResolution.
+func (a *LibratoAdapter) timeout() time.Duration {
+	if a.Timeout == 0 {
+		return resolution(0)
+	}
+	return a.Timeout
+}
+
+// do makes a single request, which is canceled at the deadline. It returns
+// true if the request can be retried.
+func (a *LibratoAdapter) do(path string, body []byte, deadline time.Time) (bool, error) {
+	u := a.URL
+	if u == "" {
+		u = LibratoURL
+	}
+
+	req, err := http.NewRequest("POST", u+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(a.user, a.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithDeadline(req.Context(), deadline)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	c := a.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("POST %s: unexpected response %d: %s", path, resp.StatusCode, bytes.TrimSpace(msg))
+
+	return resp.StatusCode/100 == 5 || resp.StatusCode == 429, err
+}
+
+// source renders the source for the metric.
+func (a *LibratoAdapter) source(m *Metric) string {
+	data := stat{
+		Container: m.Container,
+		Type:      m.Kind.String(),
+		Name:      m.Name,
+		Value:     m.Value,
+	}
+	return libratoEscape(a.template.render(data))
+}
+
+// libratoSanitizer replaces characters that aren't allowed in a Librato
+// source.
+var libratoSanitizer Sanitizer = func(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == ':' || r == '-' || r == '_')
+}
+
+// libratoEscape replaces characters that aren't allowed in a Librato source
+// with an underscore.
+func libratoEscape(s string) string {
+	return libratoSanitizer.Sanitize(s)
+}
@@ -0,0 +1,172 @@
+package stats_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/remind101/dockerstats"
+)
+
+func newLibratoAdapter(t testing.TB, url string) *stats.LibratoAdapter {
+	a, err := stats.NewLibratoAdapter("user@example.com", "token", `{{.Container.Name}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.URL = url
+	a.Backoff = time.Millisecond
+	return a
+}
+
+func TestLibratoAdapter(t *testing.T) {
+	var (
+		requests    int
+		metrics     map[string][]map[string]interface{}
+		annotations []string
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if user, token, _ := r.BasicAuth(); user != "user@example.com" || token != "token" {
+			t.Errorf("BasicAuth() => %q, %q", user, token)
+		}
+
+		switch r.URL.Path {
+		case "/v1/metrics":
+			// Fail the first request to exercise retries.
+			if requests == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+				t.Error(err)
+				return
+			}
+		case "/v1/annotations/Container.Start":
+			var a map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+				t.Error(err)
+				return
+			}
+			annotations = append(annotations, a["title"].(string))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer s.Close()
+
+	a := newLibratoAdapter(t, s.URL)
+
+	c := &docker.Container{Name: "web.1"}
+	now := time.Unix(1, 0)
+	a.Batch([]*stats.Metric{
+		{Container: c, Name: "MemoryStats.Usage", Value: 1024, Kind: stats.Gauge, Time: now},
+		{Container: c, Name: "Network.RxBytes", Value: 2048, Kind: stats.Counter, Time: now},
+	})
+	a.Emit(&stats.Metric{Container: c, Name: "Container.Start", Value: 1, Kind: stats.Event, Time: now})
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := requests, 3; got != want {
+		t.Errorf("requests => %d; want %d", got, want)
+	}
+
+	if got, want := metrics["gauges"][0]["name"], "MemoryStats.Usage"; got != want {
+		t.Errorf("gauge name => %v; want %v", got, want)
+	}
+	if got, want := metrics["gauges"][0]["source"], "web.1"; got != want {
+		t.Errorf("gauge source => %v; want %v", got, want)
+	}
+	if got, want := metrics["counters"][0]["value"], 2048.0; got != want {
+		t.Errorf("counter value => %v; want %v", got, want)
+	}
+
+	if got, want := len(annotations), 1; got != want {
+		t.Fatalf("len(annotations) => %d; want %d", got, want)
+	}
+	if got, want := annotations[0], "web.1 Container.Start"; got != want {
+		t.Errorf("annotation => %q; want %q", got, want)
+	}
+}
+
+func TestLibratoAdapter_ClientError(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, `{"errors":{"params":{"name":["is invalid"]}}}`, http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	a := newLibratoAdapter(t, s.URL)
+	a.Emit(&stats.Metric{Container: &docker.Container{Name: "web.1"}, Name: "MemoryStats.Usage", Value: 1024})
+
+	if err := a.Flush(); err == nil {
+		t.Error("Flush() => nil; want an error")
+	}
+
+	// Client errors shouldn't be retried.
+	if got, want := requests, 1; got != want {
+		t.Errorf("requests => %d; want %d", got, want)
+	}
+}
+
+func TestLibratoAdapter_Timeout(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	a := newLibratoAdapter(t, s.URL)
+	a.Backoff = time.Minute
+	a.Timeout = time.Second
+	a.Emit(&stats.Metric{Container: &docker.Container{Name: "web.1"}, Name: "MemoryStats.Usage", Value: 1024})
+
+	// Waiting for the backoff would take longer than the timeout, so the
+	// request isn't retried.
+	if err := a.Flush(); err == nil {
+		t.Error("Flush() => nil; want an error")
+	}
+
+	if got, want := requests, 1; got != want {
+		t.Errorf("requests => %d; want %d", got, want)
+	}
+}
+
+func TestLibratoAdapter_Timeout_NoResponse(t *testing.T) {
+	// The server accepts the request, but never responds.
+	closed := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-closed:
+		}
+	}))
+	defer s.Close()
+	defer close(closed)
+
+	a := newLibratoAdapter(t, s.URL)
+	a.Timeout = 50 * time.Millisecond
+	a.Emit(&stats.Metric{Container: &docker.Container{Name: "web.1"}, Name: "MemoryStats.Usage", Value: 1024})
+	a.Emit(&stats.Metric{Container: &docker.Container{Name: "web.1"}, Name: "Container.Start", Kind: stats.Event})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Flush()
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("Flush() => nil; want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Flush to return")
+	}
+}
@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// StructuredLogAdapter is an AdapterV2 that writes each metric as a single
+// structured log line, with the time, container name, ID and image, host,
+// type, name, unit, value and tags of the metric. Unlike LogAdapter, values
+// are properly escaped for the format, so every line can be parsed.
+type StructuredLogAdapter struct {
+	encode func(b *bytes.Buffer, r *logRecord) error
+
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewJSONLogAdapter returns a StructuredLogAdapter that writes each metric as
+// a JSON object on its own line. If w is nil, metrics are written to stdout.
+func NewJSONLogAdapter(w io.Writer) *StructuredLogAdapter {
+	return newStructuredLogAdapter(w, func(b *bytes.Buffer, r *logRecord) error {
+		return json.NewEncoder(b).Encode(r)
+	})
+}
+
+// NewLogfmtLogAdapter returns a StructuredLogAdapter that writes each metric
+// as a line of logfmt key=value pairs. Tags are written with a `tag.` prefix.
+// If w is nil, metrics are written to stdout.
+func NewLogfmtLogAdapter(w io.Writer) *StructuredLogAdapter {
+	return newStructuredLogAdapter(w, func(b *bytes.Buffer, r *logRecord) error {
+		r.logfmt(b)
+		return nil
+	})
+}
+
+func newStructuredLogAdapter(w io.Writer, encode func(*bytes.Buffer, *logRecord) error) *StructuredLogAdapter {
+	if w == nil {
+		w = os.Stdout
+	}
+
+	return &StructuredLogAdapter{
+		encode: encode,
+		writer: w,
+	}
+}
+
+// Emit writes a line for the metric. Values that can't be represented in
+// JSON, like NaN, are dropped.
+func (a *StructuredLogAdapter) Emit(m *Metric) {
+	if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+		return
+	}
+
+	r := newLogRecord(m)
+
+	b := new(bytes.Buffer)
+	if err := a.encode(b, r); err != nil {
+		debug("log: unable to encode %s: %v", m.Name, err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.writer.Write(b.Bytes())
+}
+
+// logRecord is the structured representation of a metric. The order of the
+// fields is the order they're written in.
+type logRecord struct {
+	Time          string            `json:"time"`
+	ContainerName string            `json:"container_name"`
+	ContainerID   string            `json:"container_id"`
+	Image         string            `json:"image"`
+	Host          string            `json:"host"`
+	Type          string            `json:"type"`
+	Name          string            `json:"name"`
+	Unit          string            `json:"unit,omitempty"`
+	Value         float64           `json:"value"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+func newLogRecord(m *Metric) *logRecord {
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	return &logRecord{
+		Time:          t.UTC().Format(time.RFC3339Nano),
+		ContainerName: m.Container.Name,
+		ContainerID:   m.Container.ID,
+		Image:         containerImage(m.Container),
+		Host:          hostname,
+		Type:          m.Kind.String(),
+		Name:          m.Name,
+		Unit:          m.Unit,
+		Value:         m.Value,
+		Tags:          m.Tags,
+	}
+}
+
+// logfmt writes the record as a logfmt line.
+func (r *logRecord) logfmt(b *bytes.Buffer) {
+	pairs := [][2]string{
+		{"time", r.Time},
+		{"container_name", r.ContainerName},
+		{"container_id", r.ContainerID},
+		{"image", r.Image},
+		{"host", r.Host},
+		{"type", r.Type},
+		{"name", r.Name},
+	}
+	if r.Unit != "" {
+		pairs = append(pairs, [2]string{"unit", r.Unit})
+	}
+	pairs = append(pairs, [2]string{"value", strconv.FormatFloat(r.Value, 'f', -1, 64)})
+
+	keys := make([]string, 0, len(r.Tags))
+	for k := range r.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pairs = append(pairs, [2]string{"tag." + k, r.Tags[k]})
+	}
+
+	for i, p := range pairs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtKey(p[0]))
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(p[1]))
+	}
+	b.WriteByte('\n')
+}
+
+// logfmtKey replaces the characters that aren't allowed in a logfmt key.
+func logfmtKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// logfmtValue quotes the value if it's empty or contains characters that
+// aren't allowed in an unquoted logfmt value.
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+
+	if strings.IndexFunc(v, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(v)
+	}
+
+	return v
+}
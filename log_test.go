@@ -0,0 +1,84 @@
+package stats_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/remind101/dockerstats"
+)
+
+var logContainer = &docker.Container{
+	ID:   "abcd",
+	Name: `web "1"`,
+	Config: &docker.Config{
+		Image: "remind101/acme-inc",
+	},
+}
+
+func TestJSONLogAdapter(t *testing.T) {
+	b := new(bytes.Buffer)
+	a := stats.NewJSONLogAdapter(b)
+
+	a.Emit(&stats.Metric{
+		Container: logContainer,
+		Name:      "BlkioStats.IOServiceBytesRecursive.8_0.Read",
+		Value:     512.5,
+		Kind:      stats.Counter,
+		Unit:      stats.UnitBytes,
+		Time:      time.Unix(1, 5).UTC(),
+		Tags:      map[string]string{"device": "8:0", "op": "Read"},
+	})
+	a.Emit(&stats.Metric{Container: logContainer, Name: "CPUStats.Percent", Value: math.NaN()})
+
+	host, _ := os.Hostname()
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("Emit() => %q; %v", b.String(), err)
+	}
+
+	want := map[string]interface{}{
+		"time":           "1970-01-01T00:00:01.000000005Z",
+		"container_name": `web "1"`,
+		"container_id":   "abcd",
+		"image":          "remind101/acme-inc",
+		"host":           host,
+		"type":           "counter",
+		"name":           "BlkioStats.IOServiceBytesRecursive.8_0.Read",
+		"unit":           "bytes",
+		"value":          512.5,
+		"tags":           map[string]interface{}{"device": "8:0", "op": "Read"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Emit() => %v; want %v", got, want)
+	}
+}
+
+func TestLogfmtLogAdapter(t *testing.T) {
+	b := new(bytes.Buffer)
+	a := stats.NewLogfmtLogAdapter(b)
+
+	a.Emit(&stats.Metric{
+		Container: logContainer,
+		Name:      "MemoryStats.Usage",
+		Value:     1024,
+		Kind:      stats.Gauge,
+		Unit:      stats.UnitBytes,
+		Time:      time.Unix(1, 0).UTC(),
+		Tags:      map[string]string{"note": "a=b"},
+	})
+
+	host, _ := os.Hostname()
+
+	want := `time=1970-01-01T00:00:01Z container_name="web \"1\"" container_id=abcd image=remind101/acme-inc host=` + host +
+		` type=gauge name=MemoryStats.Usage unit=bytes value=1024 tag.note="a=b"` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("Emit() => %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"math"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Kind describes how the value of a Metric should be interpreted.
+type Kind int
+
+const (
+	// Gauge is a point in time value, like MemoryStats.Usage.
+	Gauge Kind = iota
+
+	// Counter is a cumulative value that only goes up, until it's reset,
+	// like Network.RxBytes.
+	Counter
+
+	// Rate is a per second rate, derived from a Counter.
+	Rate
+
+	// Event is a container lifecycle event, like Container.Start. The value
+	// is the number of times that the event occurred.
+	Event
+
+	// Timing is a duration, like Container.Uptime, in nanoseconds.
+	Timing
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Gauge:
+		return "gauge"
+	case Counter:
+		return "counter"
+	case Rate:
+		return "rate"
+	case Event:
+		return "event"
+	case Timing:
+		return "timing"
+	default:
+		return "unknown"
+	}
+}
+
+// Units used by the metrics that are collected.
+const (
+	UnitBytes        = "bytes"
+	UnitCount        = "count"
+	UnitMilliseconds = "milliseconds"
+	UnitNanoseconds  = "nanoseconds"
+	UnitPackets      = "packets"
+	UnitPages        = "pages"
+	UnitPercent      = "percent"
+	UnitSectors      = "sectors"
+)
+
+// Metric is a single measurement for a container.
+type Metric struct {
+	// The container that the metric was collected from.
+	Container *docker.Container
+
+	// Name is the name of the metric, like MemoryStats.Usage.
+	Name string
+
+	// Value is the measured value.
+	Value float64
+
+	// Kind describes how Value should be interpreted.
+	Kind Kind
+
+	// Unit is the unit of Value, like "bytes". It may be empty.
+	Unit string
+
+	// Time is when the measurement was taken by the docker daemon.
+	Time time.Time
+
+	// Tags holds dimensions of the metric that are also encoded in its
+	// name, like the block device for BlkioStats or the cpu for
+	// PercpuUsage. It's nil for most metrics.
+	Tags map[string]string
+
+	// Family is the name of the metric without the dimensions in Tags,
+	// like BlkioStats.IOServiceBytesRecursive. It's empty for metrics
+	// without Tags.
+	Family string
+}
+
+// family returns the Family of the metric, falling back to its Name.
+func (m *Metric) family() string {
+	if m.Family == "" {
+		return m.Name
+	}
+	return m.Family
+}
+
+// derive returns a copy of the metric with suffix appended to its Name, and its
+// Family, separated by a ".".
+func (m *Metric) derive(suffix string) Metric {
+	d := *m
+	d.Name += "." + suffix
+	if d.Family != "" {
+		d.Family += "." + suffix
+	}
+	return d
+}
+
+// AdapterV2 is an interface for draining Metrics somewhere. Unlike Adapter,
+// it receives the value as a float64 along with the kind, unit, time and tags
+// of the metric.
+type AdapterV2 interface {
+	Emit(m *Metric)
+}
+
+// BatchAdapter is an optional interface that an AdapterV2 can implement to
+// receive metrics in batches, which is useful for adapters that send metrics
+// over HTTP. When the Adapter of a Stat implements BatchAdapter, all of the
+// metrics that are produced for a container in a single tick are passed to
+// Batch, instead of Emit, and Flush is called once per resolution, after the
+// batches for all containers have been delivered.
+type BatchAdapter interface {
+	Batch(metrics []*Metric)
+	Flush() error
+}
+
+// Forgetter is an optional interface that an Adapter or AdapterV2 can implement
+// to release the state that it keeps for a container, like the last value of
+// its counters. Forget is called when a container is destroyed, or is found to
+// no longer exist, regardless of which events are emitted.
+type Forgetter interface {
+	Forget(containerID string)
+}
+
+// AsV2 returns an AdapterV2 that drains metrics to a. If a already implements
+// AdapterV2, or was returned from AsV1, the AdapterV2 is returned as is.
+// Otherwise, events are drained with Incr and everything else with Sample,
+// after rounding the value to the nearest integer. Values that can't be
+// represented as a uint64 are dropped.
+func AsV2(a Adapter) AdapterV2 {
+	if a, ok := a.(*v2Adapter); ok {
+		return a.AdapterV2
+	}
+
+	if a, ok := a.(AdapterV2); ok {
+		return a
+	}
+
+	return &v1Adapter{a}
+}
+
+// AsV1 returns an Adapter that drains to a, so that an AdapterV2 can be used as
+// the Adapter for a Stat. The returned Adapter also implements AdapterV2, so
+// metrics emitted by Stat are passed through unchanged.
+func AsV1(a AdapterV2) Adapter {
+	return &v2Adapter{a}
+}
+
+// v1Adapter adapts an Adapter to the AdapterV2 interface.
+type v1Adapter struct {
+	Adapter
+}
+
+func (a *v1Adapter) Emit(m *Metric) {
+	if math.IsNaN(m.Value) || m.Value < 0 || m.Value >= math.MaxUint64 {
+		return
+	}
+
+	v := uint64(m.Value + 0.5)
+
+	switch m.Kind {
+	case Event:
+		a.Incr(m.Container, m.Name, v)
+	default:
+		a.Sample(m.Container, m.Name, v)
+	}
+}
+
+// v2Adapter adapts an AdapterV2 to the Adapter interface.
+type v2Adapter struct {
+	AdapterV2
+}
+
+func (a *v2Adapter) Sample(c *docker.Container, name string, value uint64) {
+	a.Emit(&Metric{Container: c, Name: name, Value: float64(value), Kind: Gauge, Time: time.Now()})
+}
+
+func (a *v2Adapter) Incr(c *docker.Container, name string, value uint64) {
+	a.Emit(&Metric{Container: c, Name: name, Value: float64(value), Kind: Event, Time: time.Now()})
+}
@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Docker labels that containers can set to configure how they're collected
+// from, without changing the configuration of dockerstats.
+const (
+	// EnableLabel stops metrics and events from being collected from the
+	// container when it's false.
+	EnableLabel = "dockerstats.enable"
+
+	// ResolutionLabel overrides the Resolution, in seconds, of the
+	// container.
+	ResolutionLabel = "dockerstats.resolution"
+
+	// WhitelistLabel overrides the Whitelist of the container, as a comma
+	// separated list of patterns.
+	WhitelistLabel = "dockerstats.whitelist"
+
+	// TemplateLabel overrides the template that the adapter renders the
+	// container's metrics with.
+	TemplateLabel = "dockerstats.template"
+)
+
+// overrides is the configuration that a container sets with its labels.
+// TemplateLabel isn't included, since it's read by the adapters.
+type overrides struct {
+	disabled   bool
+	resolution int
+	whitelist  []string
+
+	// filter is the metricFilter for the whitelist, which is created by
+	// Stat when it's first needed.
+	filter *metricFilter
+}
+
+// parseOverrides reads the overrides from the labels of the container. Labels
+// with invalid values are ignored.
+func parseOverrides(c *docker.Container) *overrides {
+	o := &overrides{}
+	if c.Config == nil {
+		return o
+	}
+
+	labels := c.Config.Labels
+
+	if v, ok := labels[EnableLabel]; ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			debug("%s: invalid %s label: %q", c.Name, EnableLabel, v)
+		} else {
+			o.disabled = !enabled
+		}
+	}
+
+	if v, ok := labels[ResolutionLabel]; ok {
+		r, err := strconv.Atoi(v)
+		if err != nil || r <= 0 {
+			debug("%s: invalid %s label: %q", c.Name, ResolutionLabel, v)
+		} else {
+			o.resolution = r
+		}
+	}
+
+	if v, ok := labels[WhitelistLabel]; ok {
+		o.whitelist = []string{}
+		for _, pattern := range strings.Split(v, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				o.whitelist = append(o.whitelist, pattern)
+			}
+		}
+	}
+
+	return o
+}
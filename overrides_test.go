@@ -0,0 +1,30 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestParseOverrides(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		out    overrides
+	}{
+		{nil, overrides{}},
+		{map[string]string{EnableLabel: "false"}, overrides{disabled: true}},
+		{map[string]string{EnableLabel: "true"}, overrides{}},
+		{map[string]string{EnableLabel: "nope"}, overrides{}},
+		{map[string]string{ResolutionLabel: "30"}, overrides{resolution: 30}},
+		{map[string]string{ResolutionLabel: "-1"}, overrides{}},
+		{map[string]string{WhitelistLabel: "MemoryStats.*, Network.RxBytes"}, overrides{whitelist: []string{"MemoryStats.*", "Network.RxBytes"}}},
+	}
+
+	for _, tt := range tests {
+		c := &docker.Container{Config: &docker.Config{Labels: tt.labels}}
+		if got := parseOverrides(c); !reflect.DeepEqual(*got, tt.out) {
+			t.Errorf("parseOverrides(%v) => %+v; want %+v", tt.labels, *got, tt.out)
+		}
+	}
+}
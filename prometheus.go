@@ -0,0 +1,293 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// PrometheusPrefix is prepended to the name of all metrics exposed by the
+// PrometheusAdapter.
+var PrometheusPrefix = "docker_"
+
+// promSeries is the latest value of a single metric for a single container.
+type promSeries struct {
+	name   string
+	typ    string
+	tags   []string
+	labels string
+	value  float64
+
+	// event is true for the series of events, and of the metrics that are
+	// emitted along with them, which aren't collected from the stats of
+	// the container.
+	event bool
+}
+
+// promContainer holds the series of a container, and the labels that they
+// share.
+type promContainer struct {
+	labels []string
+	series map[string]*promSeries
+}
+
+// PrometheusAdapter is an AdapterV2 and BatchAdapter that keeps the latest
+// value of each metric for each container and serves them over HTTP in the
+// Prometheus text exposition format. The container name, ID and image, as well
+// as any docker labels listed in Labels, are exposed as Prometheus labels.
+type PrometheusAdapter struct {
+	// Labels is a list of docker labels that will be added as labels to the
+	// metrics of each container.
+	Labels []string
+
+	// Sanitizer is applied to metric and label names. The default is
+	// PrometheusSanitizer.
+	Sanitizer Sanitizer
+
+	mu         sync.Mutex
+	containers map[string]*promContainer
+}
+
+// NewPrometheusAdapter returns a new PrometheusAdapter that exposes the given
+// docker labels.
+func NewPrometheusAdapter(labels []string) *PrometheusAdapter {
+	return &PrometheusAdapter{
+		Labels:     labels,
+		Sanitizer:  PrometheusSanitizer,
+		containers: make(map[string]*promContainer),
+	}
+}
+
+// Emit records the latest value of the metric. Events are exposed as counters
+// of the number of times they occurred.
+//
+// Series are identified by the container ID, rather than by all of their
+// labels, so that when a container is renamed, or its labels change, all of
+// its series are relabeled instead of new ones being added next to them.
+func (a *PrometheusAdapter) Emit(m *Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(a.container(m.Container), m, m.Kind == Event)
+}
+
+// Batch records the latest value of the metrics, which are all for the same
+// container. A batch without events is a collection of the stats of the
+// container, so the series of the container that were collected before, but
+// aren't in the batch, like those of a blkio device that went away, are
+// removed.
+func (a *PrometheusAdapter) Batch(metrics []*Metric) {
+	if len(metrics) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	event := false
+	for _, m := range metrics {
+		if m.Kind == Event {
+			event = true
+		}
+	}
+
+	c := a.container(metrics[0].Container)
+
+	collected := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		collected[a.record(c, m, event)] = true
+	}
+
+	if event {
+		return
+	}
+
+	for key, s := range c.series {
+		if !s.event && !collected[key] {
+			delete(c.series, key)
+		}
+	}
+}
+
+// record records the latest value of the metric in the series of the
+// container, and returns the key of the series. a.mu must be held.
+func (a *PrometheusAdapter) record(c *promContainer, m *Metric, event bool) string {
+	name := PrometheusPrefix + a.Sanitizer.Sanitize(m.family())
+	tags := a.tags(m)
+	key := name + "/" + strings.Join(tags, ",")
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &promSeries{
+			name:  name,
+			typ:   promType(m.Kind),
+			tags:  tags,
+			event: event,
+		}
+		c.series[key] = s
+	}
+
+	if m.Kind == Event {
+		s.value += m.Value
+	} else {
+		s.value = m.Value
+	}
+
+	return key
+}
+
+// Flush implements BatchAdapter. The metrics are served as they're recorded,
+// so there's nothing to flush.
+func (a *PrometheusAdapter) Flush() error {
+	return nil
+}
+
+// container returns the series of the container, after updating their labels
+// from its latest metadata. a.mu must be held.
+func (a *PrometheusAdapter) container(container *docker.Container) *promContainer {
+	if a.containers == nil {
+		a.containers = make(map[string]*promContainer)
+	}
+
+	c, ok := a.containers[container.ID]
+	if !ok {
+		c = &promContainer{series: make(map[string]*promSeries)}
+		a.containers[container.ID] = c
+	}
+	c.labels = a.labels(container)
+
+	return c
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (a *PrometheusAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	a.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+func (a *PrometheusAdapter) WriteTo(w io.Writer) (int64, error) {
+	a.mu.Lock()
+	var series []*promSeries
+	for _, c := range a.containers {
+		for _, s := range c.series {
+			s := *s
+			labels := append(append([]string(nil), c.labels...), s.tags...)
+			s.labels = "{" + strings.Join(labels, ",") + "}"
+			series = append(series, &s)
+		}
+	}
+	a.mu.Unlock()
+
+	sort.Sort(byNameAndLabels(series))
+
+	var (
+		n    int64
+		last string
+	)
+	for _, s := range series {
+		if s.name != last {
+			i, err := fmt.Fprintf(w, "# TYPE %s %s\n", s.name, s.typ)
+			n += int64(i)
+			if err != nil {
+				return n, err
+			}
+			last = s.name
+		}
+
+		i, err := fmt.Fprintf(w, "%s%s %s\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		n += int64(i)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Forget removes all of the series for the container.
+func (a *PrometheusAdapter) Forget(containerID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.containers, containerID)
+}
+
+// labels renders the labels of the container, which are shared by all of its
+// series.
+func (a *PrometheusAdapter) labels(c *docker.Container) []string {
+	labels := []string{
+		promLabel("container_name", c.Name),
+		promLabel("container_id", c.ID),
+		promLabel("image", containerImage(c)),
+	}
+
+	if c.Config != nil {
+		for _, l := range a.Labels {
+			if v, ok := c.Config.Labels[l]; ok {
+				labels = append(labels, promLabel("label_"+a.Sanitizer.Sanitize(l), v))
+			}
+		}
+	}
+
+	return labels
+}
+
+// tags renders the tags of the metric as labels, sorted by name.
+func (a *PrometheusAdapter) tags(m *Metric) []string {
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make([]string, len(keys))
+	for i, k := range keys {
+		tags[i] = promLabel(a.Sanitizer.Sanitize(k), m.Tags[k])
+	}
+	return tags
+}
+
+// promLabel renders a label pair.
+func promLabel(name, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, promLabelValue.Replace(value))
+}
+
+// containerImage returns the image that the container was created from, as it
+// was given to `docker run`, falling back to the image ID.
+func containerImage(c *docker.Container) string {
+	if c.Config != nil && c.Config.Image != "" {
+		return c.Config.Image
+	}
+	return c.Image
+}
+
+// promType returns the Prometheus type for metrics of the given kind.
+func promType(k Kind) string {
+	switch k {
+	case Counter, Event:
+		return "counter"
+	default:
+		return "gauge"
+	}
+}
+
+// promLabelValue escapes label values.
+var promLabelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type byNameAndLabels []*promSeries
+
+func (s byNameAndLabels) Len() int      { return len(s) }
+func (s byNameAndLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byNameAndLabels) Less(i, j int) bool {
+	if s[i].name != s[j].name {
+		return s[i].name < s[j].name
+	}
+	return s[i].labels < s[j].labels
+}
@@ -0,0 +1,120 @@
+package stats_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/remind101/dockerstats"
+)
+
+func TestPrometheusAdapter(t *testing.T) {
+	a := stats.NewPrometheusAdapter([]string{"com.docker.compose.service"})
+
+	c := &docker.Container{
+		ID:   "abcd",
+		Name: "web.1",
+		Config: &docker.Config{
+			Image:  "remind101/acme-inc",
+			Labels: map[string]string{"com.docker.compose.service": "web"},
+		},
+	}
+
+	a.Emit(&stats.Metric{Container: c, Name: "MemoryStats.Usage", Value: 1024, Kind: stats.Gauge})
+	a.Emit(&stats.Metric{Container: c, Name: "MemoryStats.Usage", Value: 2048, Kind: stats.Gauge})
+	a.Emit(&stats.Metric{
+		Container: c,
+		Name:      "BlkioStats.IOServiceBytesRecursive.8_0.Read",
+		Value:     512,
+		Kind:      stats.Counter,
+		Tags:      map[string]string{"device": "8:0", "op": "Read"},
+		Family:    "BlkioStats.IOServiceBytesRecursive",
+	})
+	a.Emit(&stats.Metric{Container: c, Name: "Container.Start", Value: 1, Kind: stats.Event})
+	a.Emit(&stats.Metric{Container: c, Name: "Container.Start", Value: 1, Kind: stats.Event})
+
+	labels := `container_name="web.1",container_id="abcd",image="remind101/acme-inc",label_com_docker_compose_service="web"`
+	want := `# TYPE docker_BlkioStats_IOServiceBytesRecursive counter
+docker_BlkioStats_IOServiceBytesRecursive{` + labels + `,device="8:0",op="Read"} 512
+# TYPE docker_Container_Start counter
+docker_Container_Start{` + labels + `} 2
+# TYPE docker_MemoryStats_Usage gauge
+docker_MemoryStats_Usage{` + labels + `} 2048
+`
+
+	resp := httptest.NewRecorder()
+	a.ServeHTTP(resp, &http.Request{})
+	if got := resp.Body.String(); got != want {
+		t.Errorf("ServeHTTP() => %q; want %q", got, want)
+	}
+
+	// Renaming the container relabels all of its series, including the
+	// ones that weren't emitted since, rather than adding new ones.
+	renamed := *c
+	renamed.Name = "web.2"
+	a.Emit(&stats.Metric{Container: &renamed, Name: "MemoryStats.Usage", Value: 4096, Kind: stats.Gauge})
+
+	resp = httptest.NewRecorder()
+	a.ServeHTTP(resp, &http.Request{})
+	want = strings.Replace(want, `container_name="web.1"`, `container_name="web.2"`, -1)
+	want = strings.Replace(want, "} 2048\n", "} 4096\n", 1)
+	if got := resp.Body.String(); got != want {
+		t.Errorf("ServeHTTP() => %q; want %q", got, want)
+	}
+
+	// Forgetting the container should remove all of its series.
+	a.Forget(c.ID)
+
+	resp = httptest.NewRecorder()
+	a.ServeHTTP(resp, &http.Request{})
+	if got := resp.Body.String(); got != "" {
+		t.Errorf("ServeHTTP() => %q; want %q", got, "")
+	}
+}
+
+func TestPrometheusAdapter_Batch(t *testing.T) {
+	a := stats.NewPrometheusAdapter(nil)
+
+	c := &docker.Container{ID: "abcd", Name: "web.1", Config: &docker.Config{Image: "remind101/acme-inc"}}
+	blkio := func(device string) *stats.Metric {
+		return &stats.Metric{
+			Container: c,
+			Name:      "BlkioStats.IOServiceBytesRecursive." + device + ".Read",
+			Value:     512,
+			Kind:      stats.Counter,
+			Tags:      map[string]string{"device": device, "op": "Read"},
+			Family:    "BlkioStats.IOServiceBytesRecursive",
+		}
+	}
+
+	a.Batch([]*stats.Metric{
+		{Container: c, Name: "MemoryStats.Usage", Value: 1024, Kind: stats.Gauge},
+		blkio("8:0"),
+		blkio("8:16"),
+	})
+	a.Batch([]*stats.Metric{
+		{Container: c, Name: "Container.Die", Value: 1, Kind: stats.Event},
+		{Container: c, Name: "Container.ExitCode", Value: 1, Kind: stats.Gauge},
+	})
+
+	// The next collection doesn't have one of the devices, or the memory
+	// usage, so their series are removed. The series of events are kept.
+	a.Batch([]*stats.Metric{blkio("8:0")})
+
+	labels := `container_name="web.1",container_id="abcd",image="remind101/acme-inc"`
+	want := `# TYPE docker_BlkioStats_IOServiceBytesRecursive counter
+docker_BlkioStats_IOServiceBytesRecursive{` + labels + `,device="8:0",op="Read"} 512
+# TYPE docker_Container_Die counter
+docker_Container_Die{` + labels + `} 1
+# TYPE docker_Container_ExitCode gauge
+docker_Container_ExitCode{` + labels + `} 1
+`
+
+	resp := httptest.NewRecorder()
+	a.ServeHTTP(resp, &http.Request{})
+	if got := resp.Body.String(); got != want {
+		t.Errorf("ServeHTTP() => %q; want %q", got, want)
+	}
+}
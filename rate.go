@@ -0,0 +1,54 @@
+package stats
+
+import "time"
+
+// counterSample is an observation of a cumulative counter.
+type counterSample struct {
+	value float64
+	time  time.Time
+}
+
+// rates calculates per second rates for the cumulative counters of a single
+// container.
+type rates struct {
+	// maxGap is the longest time allowed between two observations of a
+	// counter. If more time has elapsed, the observations are considered
+	// to be discontinuous and no rate is calculated.
+	maxGap time.Duration
+
+	last map[string]counterSample
+}
+
+func newRates(maxGap time.Duration) *rates {
+	return &rates{
+		maxGap: maxGap,
+		last:   make(map[string]counterSample),
+	}
+}
+
+// rate records the value of the named counter at time t and returns the per
+// second rate since the previous observation. It returns false when there is
+// no previous observation, when the counter went backwards (e.g. it was reset
+// by a restart) or when the observations are too far apart. In all of those
+// cases, the new observation becomes the base for the next rate.
+func (r *rates) rate(name string, value float64, t time.Time) (float64, bool) {
+	prev, ok := r.last[name]
+
+	// Ignore duplicate and out of order observations.
+	if ok && !t.After(prev.time) {
+		return 0, false
+	}
+
+	r.last[name] = counterSample{value: value, time: t}
+
+	if !ok {
+		return 0, false
+	}
+
+	elapsed := t.Sub(prev.time)
+	if value < prev.value || (r.maxGap > 0 && elapsed > r.maxGap) {
+		return 0, false
+	}
+
+	return (value - prev.value) / elapsed.Seconds(), true
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRates(t *testing.T) {
+	now := time.Now()
+	at := func(seconds int) time.Time {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+
+	r := newRates(30 * time.Second)
+
+	tests := []struct {
+		value float64
+		time  time.Time
+		rate  float64
+		ok    bool
+	}{
+		// First observation.
+		{100, at(0), 0, false},
+		{200, at(10), 10, true},
+		{500, at(20), 30, true},
+
+		// Duplicate observation.
+		{600, at(20), 0, false},
+
+		// Counter reset.
+		{50, at(30), 0, false},
+		{150, at(40), 10, true},
+
+		// Gap between observations.
+		{1000, at(100), 0, false},
+		{1100, at(110), 10, true},
+	}
+
+	for i, tt := range tests {
+		rate, ok := r.rate("Network.RxBytes", tt.value, tt.time)
+		if ok != tt.ok {
+			t.Errorf("#%d: ok => %v; want %v", i, ok, tt.ok)
+		}
+		if rate != tt.rate {
+			t.Errorf("#%d: rate => %v; want %v", i, rate, tt.rate)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Sanitizer reports whether a character isn't safe to use in a metric name or
+// tag for an output format. Unsafe characters are replaced with an underscore.
+// The nil Sanitizer considers every character safe.
+type Sanitizer func(r rune) bool
+
+var (
+	// StatsdSanitizer replaces characters that are significant to the
+	// statsd and DogStatsD wire formats, as well as whitespace and `/`.
+	StatsdSanitizer Sanitizer = func(r rune) bool {
+		return strings.ContainsRune(":|@#,/", r) || unsafeRune(r)
+	}
+
+	// GraphiteSanitizer replaces characters that would corrupt a graphite
+	// metric path or the plaintext protocol.
+	GraphiteSanitizer Sanitizer = func(r rune) bool {
+		return strings.ContainsRune("/", r) || unsafeRune(r)
+	}
+
+	// PrometheusSanitizer replaces characters that aren't valid in a
+	// Prometheus metric or label name.
+	PrometheusSanitizer Sanitizer = func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':')
+	}
+)
+
+// Sanitizers maps the names of the sanitization rules to their Sanitizer.
+var Sanitizers = map[string]Sanitizer{
+	"statsd":     StatsdSanitizer,
+	"graphite":   GraphiteSanitizer,
+	"prometheus": PrometheusSanitizer,
+	"none":       nil,
+}
+
+// unsafeRune reports whether r is whitespace or a control character, which
+// aren't safe in any of the output formats.
+func unsafeRune(r rune) bool {
+	return unicode.IsSpace(r) || !unicode.IsPrint(r)
+}
+
+// Sanitize replaces the unsafe characters in v with an underscore.
+func (s Sanitizer) Sanitize(v string) string {
+	if s == nil {
+		return v
+	}
+
+	return strings.Map(func(r rune) rune {
+		if s(r) {
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+// SanitizeComponent is like Sanitize but also replaces dots, so that v is a
+// single component of a dot separated metric name.
+func (s Sanitizer) SanitizeComponent(v string) string {
+	return strings.Replace(s.Sanitize(v), ".", "_", -1)
+}
@@ -0,0 +1,119 @@
+package stats_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/remind101/dockerstats"
+)
+
+// hostile is a container with a name that contains characters that are
+// significant to the statsd, graphite and prometheus formats.
+var hostile = &docker.Container{
+	ID:   "abcdef0123456789",
+	Name: "web:1|c@0.5 /x#y,z",
+	Config: &docker.Config{
+		Image:  "remind101/acme inc",
+		Env:    []string{"SOURCE=dockerstats\n"},
+		Labels: map[string]string{"com.example/role": "a|b"},
+	},
+}
+
+func TestSanitizer(t *testing.T) {
+	tests := []struct {
+		sanitizer stats.Sanitizer
+		in, out   string
+	}{
+		{stats.StatsdSanitizer, "web:1|c@0.5 /x#y,z", "web_1_c_0.5__x_y_z"},
+		{stats.GraphiteSanitizer, "web:1|c@0.5 /x#y,z", "web:1|c@0.5__x#y,z"},
+		{stats.PrometheusSanitizer, "web:1|c@0.5 /x#y,z", "web:1_c_0_5__x_y_z"},
+		{stats.Sanitizers["none"], "web:1|c@0.5 /x#y,z", "web:1|c@0.5 /x#y,z"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.sanitizer.Sanitize(tt.in); got != tt.out {
+			t.Errorf("Sanitize(%q) => %q; want %q", tt.in, got, tt.out)
+		}
+	}
+
+	if got, want := stats.StatsdSanitizer.SanitizeComponent("web.1 copy"), "web_1_copy"; got != want {
+		t.Errorf("SanitizeComponent() => %q; want %q", got, want)
+	}
+}
+
+func TestStatsdAdapter_Sanitize(t *testing.T) {
+	tests := []struct {
+		template string
+		out      string
+	}{
+		{`{{.Name}}.source__{{.Container.Name}}.{{.Env "SOURCE"}}__`, "MemoryStats.Usage.source__web_1_c_0.5__x_y_z.dockerstats___:1024|g"},
+		{`docker.{{sanitize .Container.Name}}.{{.Name}}`, "docker.web_1_c_0_5__x_y_z.MemoryStats.Usage:1024|g"},
+	}
+
+	for _, tt := range tests {
+		client := &fakeStatsdClient{Stats: []string{}}
+		a, err := stats.NewStatsdAdapter(client, tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.Sample(hostile, "MemoryStats.Usage", 1024)
+		if got := client.Stats[0]; got != tt.out {
+			t.Errorf("Sample() => %q; want %q", got, tt.out)
+		}
+	}
+}
+
+func TestStatsdAdapter_SanitizeNone(t *testing.T) {
+	client := &fakeStatsdClient{Stats: []string{}}
+	a, err := stats.NewStatsdAdapter(client, `{{.Container.Name}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Sanitizer = stats.Sanitizers["none"]
+
+	a.Sample(hostile, "MemoryStats.Usage", 1024)
+	if got, want := client.Stats[0], "web:1|c@0.5 /x#y,z:1024|g"; got != want {
+		t.Errorf("Sample() => %q; want %q", got, want)
+	}
+}
+
+func TestDogStatsdAdapter_Sanitize(t *testing.T) {
+	client := &fakeTaggedStatsdClient{fakeStatsdClient: &fakeStatsdClient{Stats: []string{}}}
+	a, err := stats.NewDogStatsdAdapter(client, "", `container_name:{{.Container.Name}},image:{{.Image}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Labels = []string{"com.example/role"}
+	a.Env = []string{"SOURCE"}
+
+	a.Sample(hostile, "MemoryStats.Usage", 1024)
+	want := "docker.MemoryStats.Usage|#container_name:web_1_c_0.5__x_y_z,image:remind101_acme_inc,com.example_role:a_b,SOURCE:dockerstats_:1024|g"
+	if got := client.Stats[0]; got != want {
+		t.Errorf("Sample() => %q; want %q", got, want)
+	}
+}
+
+func TestPrometheusAdapter_Sanitize(t *testing.T) {
+	a := stats.NewPrometheusAdapter([]string{"com.example/role"})
+
+	a.Emit(&stats.Metric{
+		Container: hostile,
+		Name:      "Memory Stats.Usage",
+		Value:     1024,
+		Kind:      stats.Gauge,
+		Tags:      map[string]string{"device id": "8:0"},
+	})
+
+	want := `# TYPE docker_Memory_Stats_Usage gauge
+docker_Memory_Stats_Usage{container_name="web:1|c@0.5 /x#y,z",container_id="abcdef0123456789",image="remind101/acme inc",label_com_example_role="a|b",device_id="8:0"} 1024
+`
+
+	resp := httptest.NewRecorder()
+	a.ServeHTTP(resp, &http.Request{})
+	if got := resp.Body.String(); got != want {
+		t.Errorf("ServeHTTP() => %q; want %q", got, want)
+	}
+}
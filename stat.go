@@ -20,22 +20,87 @@ type stat struct {
 	Type      string
 	Name      string
 	Value     interface{}
+
+	// escape, if set, is applied to the values returned by Hostname and
+	// Env, so they're safe to use in the output format of the adapter.
+	escape func(string) string
 }
 
 func (s stat) Hostname() string {
-	return hostname
+	return s.esc(hostname)
 }
 
 // Returns the first 12 characters of the container ID.
 func (s stat) ID() string {
+	if len(s.Container.ID) < 12 {
+		return s.Container.ID
+	}
 	return s.Container.ID[:12]
 }
 
+// Returns the image that the container was created from.
+func (s stat) Image() string {
+	return s.esc(containerImage(s.Container))
+}
+
+// Returns the tag of the image that the container was created from, or latest
+// if the image wasn't tagged.
+func (s stat) ImageTag() string {
+	image := containerImage(s.Container)
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+
+	// A colon before the last slash separates the port of the registry.
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		return s.esc(image[i+1:])
+	}
+	return "latest"
+}
+
+// Returns the name of the container without the leading slash that docker
+// adds.
+func (s stat) ShortName() string {
+	return s.esc(strings.TrimPrefix(s.Container.Name, "/"))
+}
+
+// Returns the value of a docker label on the container, or an empty string if
+// the label isn't set.
+func (s stat) Label(key string) string {
+	if s.Container.Config == nil {
+		return ""
+	}
+	return s.esc(s.Container.Config.Labels[key])
+}
+
 func (s stat) Env(key string) string {
+	if s.Container.Config == nil {
+		return ""
+	}
+
 	for _, env := range s.Container.Config.Env {
 		if strings.HasPrefix(env, key+"=") {
-			return env[len(key)+1:]
+			return s.esc(env[len(key)+1:])
 		}
 	}
 	return ""
 }
+
+// escapeContainer returns a copy of the container with its name escaped, for
+// use as the Container of a stat with the same escape func.
+func escapeContainer(c *docker.Container, escape func(string) string) *docker.Container {
+	if c == nil {
+		return nil
+	}
+
+	cc := *c
+	cc.Name = escape(c.Name)
+	return &cc
+}
+
+func (s stat) esc(v string) string {
+	if s.escape == nil {
+		return v
+	}
+	return s.escape(v)
+}
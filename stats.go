@@ -4,15 +4,15 @@
 package stats // import "github.com/remind101/dockerstats"
 
 import (
-	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
-	"github.com/mb0/glob"
 	"github.com/remind101/empire/pkg/dockerutil"
 )
 
@@ -20,29 +20,47 @@ import (
 // default to 10 seconds.
 var DefaultResolution = 10
 
-var eventList = map[string]bool{
-	// Events that should be handled.
-	"create":      true,
-	"destroy":     true,
-	"die":         true,
-	"exec_create": true,
-	"exec_start":  true,
-	"export":      true,
-	"kill":        true,
-	"oom":         true,
-	"pause":       true,
-	"restart":     true,
-	"start":       true,
-	"stop":        true,
-	"unpause":     true,
-
-	// Events that should not be handled
-	// See https://docs.docker.com/reference/api/docker_remote_api_v1.19/#monitor-docker-s-events
-	"untag":  false,
-	"delete": false,
-}
-
-// Adapter is an interface for draining stats and events somewhere.
+// ReconcileInterval is how often the known containers are checked against the
+// containers that exist, to forget containers whose destroy event was missed.
+var ReconcileInterval = time.Minute
+
+// ReconnectBackoff is how long to wait before reconnecting to the docker
+// events API when the stream ends, for example because the daemon restarted.
+// The wait is doubled after each failed attempt, up to MaxReconnectBackoff.
+var (
+	ReconnectBackoff    = time.Second
+	MaxReconnectBackoff = 30 * time.Second
+)
+
+// eventReconnects is the number of times that the docker events API was
+// reconnected to.
+var eventReconnects = newInternalCounter("Internal.Events.Reconnects")
+
+// trackedContainers is the number of containers that are known.
+var trackedContainers = newInternalGauge("Internal.Containers.Tracked")
+
+// DefaultEvents is the list of docker events that are emitted when the Events
+// of a Stat is empty.
+// See https://docs.docker.com/reference/api/docker_remote_api_v1.19/#monitor-docker-s-events
+var DefaultEvents = []string{
+	"create",
+	"destroy",
+	"die",
+	"exec_create",
+	"exec_start",
+	"export",
+	"kill",
+	"oom",
+	"pause",
+	"restart",
+	"start",
+	"stop",
+	"unpause",
+}
+
+// Adapter is an interface for draining stats and events somewhere. See
+// AdapterV2 for an interface that receives richer Metrics; Stat will use it
+// when the Adapter also implements AdapterV2.
 type Adapter interface {
 	Sample(container *docker.Container, name string, value uint64)
 	Incr(container *docker.Container, name string, value uint64)
@@ -57,17 +75,86 @@ type Stat struct {
 
 	// Resolution defines how often stats will be sent to the adapter to be
 	// drained. Any stats received from the docker daemon before the next
-	// tick will be dropped. Throttling is on a per container basis. The
-	// zero value is DefaultResolution.
+	// tick will be dropped, unless Aggregate is enabled. Throttling is on a
+	// per container basis. The zero value is DefaultResolution.
 	Resolution int
 
+	// Aggregate enables aggregating gauges, like MemoryStats.Usage, over
+	// all of the stats received within the resolution window. Instead of
+	// the latest value, each gauge is sampled as `<name>.min`, `<name>.max`,
+	// `<name>.mean` and `<name>.last`.
+	Aggregate bool
+
+	// Rates enables sampling the per second rate of cumulative counters,
+	// like Network.RxBytes, as `<name>.Rate`. Rates are calculated from the
+	// time that the docker daemon read the stats.
+	Rates bool
+
 	// Whitelist is a list of stats to output to the adapter. An empty whitelist
-	// will include all stats. Whitelisted stats can use `*` for wildcard matches.
+	// will include all stats. Whitelisted stats can use `*` for wildcard matches,
+	// or be regular expressions when they're prefixed with `~`.
 	Whitelist []string
 
+	// Blacklist is a list of stats not to output to the adapter, even if
+	// they're whitelisted. Patterns are the same as in Whitelist.
+	Blacklist []string
+
+	// Events is the list of docker events that are emitted as
+	// `Container.<Event>`. The zero value is DefaultEvents. Metrics are
+	// collected from containers that start or restart regardless.
+	Events []string
+
+	// Include and Exclude are filters that decide which containers metrics
+	// and events are collected from. A container is collected from when it
+	// matches any of the Include filters, or there are none, and doesn't
+	// match any of the Exclude filters. Filters match containers by name or
+	// image, using `*` for wildcard matches, or by label:
+	//
+	//	name=web.*
+	//	image=remind101/*
+	//	label=com.docker.compose.service
+	//	label=com.docker.compose.oneoff=True
+	//
+	// Filters prefixed with `!` match the containers that don't match the
+	// rest of the filter.
+	//
+	// Containers can also opt out with the dockerstats.enable=false label,
+	// and override the Resolution and Whitelist with labels. See
+	// EnableLabel, ResolutionLabel and WhitelistLabel.
+	Include []string
+	Exclude []string
+
+	filters *containerFilters
+	metrics *metricFilter
+
 	mu         sync.Mutex
 	containers map[string]*docker.Container
 	client     *docker.Client
+	api        *dockerAPI
+
+	// stop is closed when Stop is called, and stream is the current
+	// connection to the events API, which Stop closes.
+	stop   chan struct{}
+	stream *eventStream
+
+	// overrides holds the configuration that each container sets with its
+	// labels.
+	overrides map[string]*overrides
+
+	// collectors holds the collector of each container that metrics are
+	// being collected from.
+	collectors map[string]*collector
+
+	// previous holds the last stats sample that was drained for each
+	// container, which is used to calculate derived metrics.
+	previous map[string]*docker.Stats
+
+	// counters holds the state for calculating rates for each container.
+	counters map[string]*rates
+
+	// latest holds the last stats sample that was received for each
+	// container, whether or not it was drained.
+	latest map[string]*docker.Stats
 }
 
 // New returns a new Stat instance with a configured docker client.
@@ -77,56 +164,294 @@ func New() (*Stat, error) {
 		return nil, err
 	}
 
+	// The events and stats APIs are streamed without the docker client, so
+	// that the streams can be resumed and closed. See dockerAPI.
+	api, err := newDockerAPI(os.Getenv("DOCKER_HOST"), c.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Stat{
 		client:     c,
+		api:        api,
 		containers: make(map[string]*docker.Container),
+		overrides:  make(map[string]*overrides),
+		collectors: make(map[string]*collector),
+		previous:   make(map[string]*docker.Stats),
+		counters:   make(map[string]*rates),
+		latest:     make(map[string]*docker.Stats),
 	}, nil
 }
 
 // Run begins starts draining metrics and events from all of the currently
 // running containers and starts watching for new containers to drain metrics
-// and events from. This call is blocking.
+// and events from. When the docker events stream ends, for example because the
+// daemon restarted, it's reconnected to with an exponential backoff, and
+// resumed from the last event, so that no events are missed. This call is
+// blocking, until Stop is called.
 func (s *Stat) Run() error {
-	containers, err := s.client.ListContainers(docker.ListContainersOptions{})
+	filters, err := newContainerFilters(s.Include, s.Exclude)
 	if err != nil {
 		return err
 	}
+	s.filters = filters
 
-	for _, c := range containers {
-		container, err := s.addContainer(c.ID)
+	metrics, err := newMetricFilter(s.Whitelist, s.Blacklist)
+	if err != nil {
+		return err
+	}
+	s.metrics = metrics
+
+	stop := s.stopping()
+
+	events, err := s.api.Events(0)
+	if err != nil {
+		return err
+	}
+	connected := time.Now().Unix()
+
+	if err := s.resync(); err != nil {
+		events.Close()
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	defer s.stopCollectors()
+	go s.tick(done)
+	go s.reconcile(done)
+
+	cursor := &eventCursor{}
+	backoff := ReconnectBackoff
+
+	for {
+		if !s.setStream(events) {
+			events.Close()
+			return nil
+		}
+
+		s.watch(events, cursor)
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// Resume the stream from the last event that was handled, or from
+		// when the stream was connected if there wasn't one.
+		since := cursor.since
+		if since == 0 {
+			since = connected
+		}
+		cursor.Resume()
+
+		for {
+			debug("events: reconnecting in %s", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return nil
+			}
+
+			if events, err = s.api.Events(since); err == nil {
+				break
+			}
+			debug("events: err: %s", err)
+
+			if backoff *= 2; backoff > MaxReconnectBackoff {
+				backoff = MaxReconnectBackoff
+			}
+		}
+		backoff = ReconnectBackoff
+		eventReconnects.Incr()
+
+		// Containers that started or were removed while the stream was
+		// disconnected are picked up from the replayed events, but the
+		// stats streams of the containers also end when the daemon
+		// restarts, so they're all reattached.
+		if err := s.resync(); err != nil {
+			debug("resync: err: %s", err)
+		}
+	}
+}
+
+// Stop stops Run, which returns nil, and stops collecting metrics from all of
+// the containers.
+func (s *Stat) Stop() {
+	s.mu.Lock()
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+	}
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	stream := s.stream
+	s.mu.Unlock()
+
+	if stream != nil {
+		stream.Close()
+	}
+}
+
+// stopping returns the channel that's closed when Stop is called.
+func (s *Stat) stopping() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+	}
+	return s.stop
+}
+
+// setStream sets the current connection to the events API, so that Stop can
+// close it. It returns false if Stop was already called.
+func (s *Stat) setStream(events *eventStream) bool {
+	stop := s.stopping()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-stop:
+		return false
+	default:
+		s.stream = events
+		return true
+	}
+}
+
+// stopCollectors stops collecting metrics from all of the containers.
+func (s *Stat) stopCollectors() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, c := range s.collectors {
+		close(c.stop)
+		delete(s.collectors, id)
+	}
+}
+
+// watch handles the events from the stream until it ends, skipping the events
+// that the cursor has already seen.
+func (s *Stat) watch(events *eventStream, cursor *eventCursor) {
+	defer events.Close()
+
+	for {
+		event, err := events.Next()
 		if err != nil {
-			return err
+			if err != io.EOF {
+				debug("events: err: %s", err)
+			}
+			return
 		}
 
-		go s.attachMetrics(container)
+		if cursor.Advance(&event.APIEvents) {
+			s.handle(event)
+		}
 	}
+}
 
-	events := make(chan *docker.APIEvents)
-	if err := s.client.AddEventListener(events); err != nil {
+// resync adds all of the running containers, and starts collecting metrics from
+// the ones that are collected from, unless they already are. Containers that no
+// longer exist are removed.
+func (s *Stat) resync() error {
+	containers, err := s.client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
 		return err
 	}
 
-	for event := range events {
-		// Ignore events that are not whitelisted.
-		if !eventList[event.Status] {
-			continue
+	for _, c := range containers {
+		container, err := s.refreshContainer(c.ID)
+		if err != nil {
+			return err
 		}
 
-		container, err := s.addContainer(event.ID)
-		if err != nil {
-			debug("add container: err: %s", err)
+		if !s.collected(container) {
+			debug("filtered: %s", container.Name)
 			continue
 		}
 
-		go s.event(container, event)
+		s.startCollector(container)
+	}
+
+	return s.reconcileContainers()
+}
+
+// handle handles a docker event. Metrics are collected from containers that
+// start or restart, until they die, the event is emitted if it's one of the Events, and
+// containers that are destroyed are forgotten. The metadata of containers that
+// start, restart, are renamed or updated is refreshed.
+func (s *Stat) handle(event *dockerEvent) {
+	attach := event.Status == "start" || event.Status == "restart"
+	destroy := event.Status == "destroy"
+
+	// The latest sample is read before the collector is stopped by the
+	// die event that follows, which forgets it.
+	if event.Status == "oom" {
+		event.stats = s.latestSample(event.ID)
+	}
+
+	// The stats stream of a container stays open until the container is
+	// removed, so it's stopped when the container dies, and a new one is
+	// started if it starts again.
+	if event.Status == "die" || destroy {
+		s.stopCollector(event.ID)
+	}
+
+	refresh := attach || event.Status == "rename" || event.Status == "update"
+	emit := s.emits(event.Status)
+
+	// Ignore events that are not emitted, unless they change the
+	// containers that are tracked, or their metadata.
+	if !emit && !refresh && !destroy {
+		return
+	}
+
+	add := s.addContainer
+	if refresh {
+		add = s.refreshContainer
+	}
+
+	container, err := add(event.ID)
+	if err != nil {
+		debug("add container: err: %s", err)
+
+		// A destroyed container that wasn't known can't be
+		// inspected, but the adapter may still keep state for it.
+		if destroy {
+			s.removeContainer(event.ID)
+		}
+		return
+	}
 
-		switch event.Status {
-		case "start", "restart":
-			go s.attachMetrics(container)
+	// Whether or not the destroy event is emitted, the container is
+	// removed, and the adapter told to forget it.
+	if !s.collected(container) {
+		if destroy {
+			s.removeContainer(container.ID)
 		}
+		return
 	}
 
-	return errors.New("unexpected stop")
+	go func() {
+		if emit {
+			s.event(container, event)
+		}
+
+		// The container is removed after the destroy event is
+		// emitted, so that the event is rendered with its metadata.
+		if destroy {
+			s.removeContainer(container.ID)
+		}
+	}()
+
+	if attach {
+		s.startCollector(container)
+	}
 }
 
 // addContainer adds the container to the internal map of known containers.
@@ -139,6 +464,23 @@ func (s *Stat) addContainer(containerID string) (*docker.Container, error) {
 		return container, nil
 	}
 
+	return s.inspectContainer(containerID)
+}
+
+// refreshContainer inspects the container again, replacing its metadata in
+// the internal map of known containers. The cached container is replaced
+// rather than changed, so that metrics that are being drained for it aren't
+// affected, and attachMetrics uses the new metadata from its next tick.
+func (s *Stat) refreshContainer(containerID string) (*docker.Container, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.inspectContainer(containerID)
+}
+
+// inspectContainer inspects the container and stores it in the internal map of
+// known containers. s.mu must be held.
+func (s *Stat) inspectContainer(containerID string) (*docker.Container, error) {
 	container, err := s.client.InspectContainer(containerID)
 	if err != nil {
 		debug("inspect: err: %s", err)
@@ -146,12 +488,179 @@ func (s *Stat) addContainer(containerID string) (*docker.Container, error) {
 	}
 	container.Name = strings.Replace(container.Name, "/", "", 1)
 
+	if s.containers == nil {
+		s.containers = make(map[string]*docker.Container)
+	}
+
+	if s.overrides == nil {
+		s.overrides = make(map[string]*overrides)
+	}
+
 	s.containers[containerID] = container
+	s.overrides[containerID] = parseOverrides(container)
+	trackedContainers.Set(uint64(len(s.containers)))
 
 	return container, nil
 }
 
-func (s *Stat) attachMetrics(container *docker.Container) {
+// container returns the latest metadata for the container, or nil if it's
+// not known.
+func (s *Stat) container(containerID string) *docker.Container {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.containers[containerID]
+}
+
+// removeContainer removes the container, and all of the state that was kept
+// for it, from the internal map of known containers.
+func (s *Stat) removeContainer(containerID string) {
+	s.mu.Lock()
+
+	if c, ok := s.collectors[containerID]; ok {
+		close(c.stop)
+		delete(s.collectors, containerID)
+	}
+
+	delete(s.containers, containerID)
+	delete(s.overrides, containerID)
+	delete(s.previous, containerID)
+	delete(s.counters, containerID)
+	delete(s.latest, containerID)
+	trackedContainers.Set(uint64(len(s.containers)))
+
+	s.mu.Unlock()
+
+	s.forget(containerID)
+}
+
+// forget tells the adapter to release the state that it keeps for the
+// container, if it's a Forgetter.
+func (s *Stat) forget(containerID string) {
+	var a interface{} = s.adapter()
+	if v1, ok := a.(*v1Adapter); ok {
+		a = v1.Adapter
+	}
+
+	if f, ok := a.(Forgetter); ok {
+		f.Forget(containerID)
+	}
+}
+
+// reconcile removes containers that no longer exist from the internal map of
+// known containers every ReconcileInterval, until done is closed, in case
+// their destroy event was missed.
+func (s *Stat) reconcile(done <-chan struct{}) {
+	ticker := time.NewTicker(ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.reconcileContainers(); err != nil {
+				debug("reconcile: err: %s", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// reconcileContainers removes the known containers that aren't in
+// ListContainers. Only containers that were known before listing are removed,
+// so that containers that are added while listing aren't.
+func (s *Stat) reconcileContainers() error {
+	s.mu.Lock()
+	known := make([]string, 0, len(s.containers))
+	for id := range s.containers {
+		known = append(known, id)
+	}
+	s.mu.Unlock()
+
+	containers, err := s.client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		exists[c.ID] = true
+	}
+
+	for _, id := range known {
+		if !exists[id] {
+			debug("reconcile: removing %s", id)
+			s.removeContainer(id)
+		}
+	}
+
+	return nil
+}
+
+// collector collects metrics from a single container, until it's stopped.
+type collector struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startCollector starts collecting metrics from the container, unless they're
+// already being collected, so that there's only ever one stats stream for a
+// container.
+func (s *Stat) startCollector(container *docker.Container) *collector {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.collectors[container.ID]; ok {
+		return c
+	}
+
+	if s.collectors == nil {
+		s.collectors = make(map[string]*collector)
+	}
+
+	c := &collector{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.collectors[container.ID] = c
+
+	go func() {
+		defer close(c.done)
+
+		s.attachMetrics(container, c.stop)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// Unless a new collector was started for the container after
+		// this one was stopped, forget the state that was kept for
+		// deriving metrics, so that a restarted container starts from a
+		// clean slate.
+		if current, ok := s.collectors[container.ID]; !ok || current == c {
+			delete(s.collectors, container.ID)
+			delete(s.previous, container.ID)
+			delete(s.counters, container.ID)
+			delete(s.latest, container.ID)
+		}
+	}()
+
+	return c
+}
+
+// stopCollector stops collecting metrics from the container.
+func (s *Stat) stopCollector(containerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.collectors[containerID]; ok {
+		close(c.stop)
+		delete(s.collectors, containerID)
+	}
+}
+
+// attachMetrics drains metrics from the stats stream of the container until
+// the stream ends or stop is closed.
+func (s *Stat) attachMetrics(container *docker.Container, stop <-chan struct{}) {
 	defer func() {
 		if v := recover(); v != nil {
 			debug("recovered panic in attachMetrics: %v", v)
@@ -160,113 +669,437 @@ func (s *Stat) attachMetrics(container *docker.Container) {
 
 	debug("draining: %s", container.Name)
 
+	stream, err := s.api.Stats(container.ID)
+	if err != nil {
+		debug("stats: err: %s", err)
+		return
+	}
+
+	// The stream is closed when the collector is stopped, which ends it,
+	// so that it's not left open until the container is removed.
+	defer stream.Close()
+
 	stats := make(chan *docker.Stats)
 	go func() {
-		if err := s.client.Stats(docker.StatsOptions{
-			ID:    container.ID,
-			Stats: stats,
-		}); err != nil {
-			debug("stats: err: %s", err)
+		defer close(stats)
+
+		for {
+			stat, err := stream.Next()
+			if err != nil {
+				if err != io.EOF {
+					debug("stats: err: %s", err)
+				}
+				return
+			}
+
+			select {
+			case stats <- stat:
+			case <-stop:
+				return
+			}
 		}
 	}()
 
-	ticker := newTicker(s.Resolution)
+	ticker := newTicker(s.resolutionFor(container.ID))
+	defer ticker.Stop()
+
+	var w *window
+	if s.Aggregate {
+		w = newWindow()
+	}
+
+	for {
+		var stat *docker.Stats
+
+		select {
+		case stat = <-stats:
+			if stat == nil {
+				return
+			}
+			s.setLatestSample(container.ID, stat)
+		case <-stop:
+			debug("stopped draining: %s", container.Name)
+			return
+		}
+
+		if w != nil {
+			w.add(stat)
+		}
 
-	for stat := range stats {
 		// We select on the ticker channel. If a tick event isn't ready, we'll
-		// return which will drop this stats message.
+		// return which will drop this stats message, unless it was added
+		// to the window.
 		select {
 		case <-ticker.C:
-			s.stats(container, stat)
+			// Pick up metadata that was refreshed since the last
+			// tick, like a new name.
+			if c := s.container(container.ID); c != nil {
+				container = c
+			}
+
+			s.stats(container, stat, w)
+			if w != nil {
+				w.reset()
+			}
 		default:
 			// Drop the stat.
 		}
 	}
 }
 
-func (s *Stat) stats(container *docker.Container, stats *docker.Stats) {
-	sample := func(name string, value uint64) {
-		if s.whitelisted(name) {
-			s.adapter().Sample(container, name, value)
+// stats drains a stats sample to the adapter. If w is not nil, gauges are
+// drained as aggregates over the window instead of as their latest value.
+func (s *Stat) stats(container *docker.Container, stats *docker.Stats, w *window) {
+	t := stats.Read
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	var metrics []*Metric
+
+	filter := s.metricFilter(container.ID)
+	add := func(m Metric) {
+		m.Container = container
+		m.Time = t
+		metrics = append(metrics, &m)
+	}
+	emit := func(m Metric) {
+		if filter.Match(m.Name) {
+			add(m)
 		}
 	}
 
+	var r *rates
+	if s.Rates && !stats.Read.IsZero() {
+		r = s.rates(container.ID)
+	}
+
+	walk(stats, func(m Metric) {
+		if m.Kind == Gauge && w != nil {
+			// Emit the aggregates of the gauge over the window instead
+			// of its latest value. They're filtered by the name of the
+			// gauge, as well as their own.
+			w.each(m, func(agg Metric) {
+				if filter.MatchDerived(agg.Name, m.Name) {
+					add(agg)
+				}
+			})
+			return
+		}
+
+		emit(m)
+
+		// When rates are enabled, also emit the per second rate of
+		// counters as `<name>.Rate`.
+		if m.Kind == Counter && r != nil {
+			rate := m.derive("Rate")
+			rate.Kind = Rate
+			rate.Unit += "/s"
+			if !filter.MatchDerived(rate.Name, m.Name) {
+				return
+			}
+
+			if v, ok := r.rate(rate.Name, m.Value, stats.Read); ok {
+				rate.Value = v
+				add(rate)
+			}
+		}
+	})
+
+	// Derived CPU utilization.
+	if cpu, ok := calculateCPU(s.swapPrevious(container.ID, stats), stats); ok {
+		percent := func(name string, value float64) {
+			emit(Metric{Name: name, Value: value, Kind: Gauge, Unit: UnitPercent})
+		}
+		percent("CPUStats.Percent", cpu.Percent)
+		percent("CPUStats.UserPercent", cpu.UserPercent)
+		percent("CPUStats.KernelPercent", cpu.KernelPercent)
+		percent("CPUStats.NormalizedPercent", cpu.NormalizedPercent)
+	}
+
+	s.drain(metrics)
+}
+
+// walk calls fn with a Metric for every raw stat in stats. The Container and
+// Time of the metrics are left empty.
+func walk(stats *docker.Stats, fn func(Metric)) {
+	gauge := func(name, unit string, value uint64) {
+		fn(Metric{Name: name, Value: float64(value), Kind: Gauge, Unit: unit})
+	}
+	counter := func(name, unit string, value uint64) {
+		fn(Metric{Name: name, Value: float64(value), Kind: Counter, Unit: unit})
+	}
+
 	// Network
-	sample("Network.RxDropped", stats.Network.RxDropped)
-	sample("Network.RxBytes", stats.Network.RxBytes)
-	sample("Network.RxErrors", stats.Network.RxErrors)
-	sample("Network.TxPackets", stats.Network.TxPackets)
-	sample("Network.RxPackets", stats.Network.RxPackets)
-	sample("Network.TxErrors", stats.Network.TxErrors)
-	sample("Network.TxBytes", stats.Network.TxBytes)
+	counter("Network.RxDropped", UnitPackets, stats.Network.RxDropped)
+	counter("Network.RxBytes", UnitBytes, stats.Network.RxBytes)
+	counter("Network.RxErrors", UnitPackets, stats.Network.RxErrors)
+	counter("Network.TxPackets", UnitPackets, stats.Network.TxPackets)
+	counter("Network.RxPackets", UnitPackets, stats.Network.RxPackets)
+	counter("Network.TxErrors", UnitPackets, stats.Network.TxErrors)
+	counter("Network.TxBytes", UnitBytes, stats.Network.TxBytes)
 
 	// MemoryStats
-	sample("MemoryStats.Stats.TotalPgmafault", stats.MemoryStats.Stats.TotalPgmafault)
-	sample("MemoryStats.Stats.Cache", stats.MemoryStats.Stats.Cache)
-	sample("MemoryStats.Stats.MappedFile", stats.MemoryStats.Stats.MappedFile)
-	sample("MemoryStats.Stats.TotalInactiveFile", stats.MemoryStats.Stats.TotalInactiveFile)
-	sample("MemoryStats.Stats.Pgpgout", stats.MemoryStats.Stats.Pgpgout)
-	sample("MemoryStats.Stats.Rss", stats.MemoryStats.Stats.Rss)
-	sample("MemoryStats.Stats.TotalMappedFile", stats.MemoryStats.Stats.TotalMappedFile)
-	sample("MemoryStats.Stats.Writeback", stats.MemoryStats.Stats.Writeback)
-	sample("MemoryStats.Stats.Unevictable", stats.MemoryStats.Stats.Unevictable)
-	sample("MemoryStats.Stats.Pgpgin", stats.MemoryStats.Stats.Pgpgin)
-	sample("MemoryStats.Stats.TotalUnevictable", stats.MemoryStats.Stats.TotalUnevictable)
-	sample("MemoryStats.Stats.Pgmajfault", stats.MemoryStats.Stats.Pgmajfault)
-	sample("MemoryStats.Stats.TotalRss", stats.MemoryStats.Stats.TotalRss)
-	sample("MemoryStats.Stats.TotalRssHuge", stats.MemoryStats.Stats.TotalRssHuge)
-	sample("MemoryStats.Stats.TotalWriteback", stats.MemoryStats.Stats.TotalWriteback)
-	sample("MemoryStats.Stats.TotalInactiveAnon", stats.MemoryStats.Stats.TotalInactiveAnon)
-	sample("MemoryStats.Stats.RssHuge", stats.MemoryStats.Stats.RssHuge)
-	sample("MemoryStats.Stats.HierarchicalMemoryLimit", stats.MemoryStats.Stats.HierarchicalMemoryLimit)
-	sample("MemoryStats.Stats.TotalPgfault", stats.MemoryStats.Stats.TotalPgfault)
-	sample("MemoryStats.Stats.TotalActiveFile", stats.MemoryStats.Stats.TotalActiveFile)
-	sample("MemoryStats.Stats.ActiveAnon", stats.MemoryStats.Stats.ActiveAnon)
-	sample("MemoryStats.Stats.TotalActiveAnon", stats.MemoryStats.Stats.TotalActiveAnon)
-	sample("MemoryStats.Stats.TotalPgpgout", stats.MemoryStats.Stats.TotalPgpgout)
-	sample("MemoryStats.Stats.TotalCache", stats.MemoryStats.Stats.TotalCache)
-	sample("MemoryStats.Stats.InactiveAnon", stats.MemoryStats.Stats.InactiveAnon)
-	sample("MemoryStats.Stats.ActiveFile", stats.MemoryStats.Stats.ActiveFile)
-	sample("MemoryStats.Stats.Pgfault", stats.MemoryStats.Stats.Pgfault)
-	sample("MemoryStats.Stats.InactiveFile", stats.MemoryStats.Stats.InactiveFile)
-	sample("MemoryStats.Stats.TotalPgpgin", stats.MemoryStats.Stats.TotalPgpgin)
-	sample("MemoryStats.MaxUsage", stats.MemoryStats.MaxUsage)
-	sample("MemoryStats.Usage", stats.MemoryStats.Usage)
-	sample("MemoryStats.Failcnt", stats.MemoryStats.Failcnt)
-	sample("MemoryStats.Limit", stats.MemoryStats.Limit)
+	counter("MemoryStats.Stats.TotalPgmafault", UnitCount, stats.MemoryStats.Stats.TotalPgmafault)
+	gauge("MemoryStats.Stats.Cache", UnitBytes, stats.MemoryStats.Stats.Cache)
+	gauge("MemoryStats.Stats.MappedFile", UnitBytes, stats.MemoryStats.Stats.MappedFile)
+	gauge("MemoryStats.Stats.TotalInactiveFile", UnitBytes, stats.MemoryStats.Stats.TotalInactiveFile)
+	counter("MemoryStats.Stats.Pgpgout", UnitPages, stats.MemoryStats.Stats.Pgpgout)
+	gauge("MemoryStats.Stats.Rss", UnitBytes, stats.MemoryStats.Stats.Rss)
+	gauge("MemoryStats.Stats.TotalMappedFile", UnitBytes, stats.MemoryStats.Stats.TotalMappedFile)
+	gauge("MemoryStats.Stats.Writeback", UnitBytes, stats.MemoryStats.Stats.Writeback)
+	gauge("MemoryStats.Stats.Unevictable", UnitBytes, stats.MemoryStats.Stats.Unevictable)
+	counter("MemoryStats.Stats.Pgpgin", UnitPages, stats.MemoryStats.Stats.Pgpgin)
+	gauge("MemoryStats.Stats.TotalUnevictable", UnitBytes, stats.MemoryStats.Stats.TotalUnevictable)
+	counter("MemoryStats.Stats.Pgmajfault", UnitCount, stats.MemoryStats.Stats.Pgmajfault)
+	gauge("MemoryStats.Stats.TotalRss", UnitBytes, stats.MemoryStats.Stats.TotalRss)
+	gauge("MemoryStats.Stats.TotalRssHuge", UnitBytes, stats.MemoryStats.Stats.TotalRssHuge)
+	gauge("MemoryStats.Stats.TotalWriteback", UnitBytes, stats.MemoryStats.Stats.TotalWriteback)
+	gauge("MemoryStats.Stats.TotalInactiveAnon", UnitBytes, stats.MemoryStats.Stats.TotalInactiveAnon)
+	gauge("MemoryStats.Stats.RssHuge", UnitBytes, stats.MemoryStats.Stats.RssHuge)
+	gauge("MemoryStats.Stats.HierarchicalMemoryLimit", UnitBytes, stats.MemoryStats.Stats.HierarchicalMemoryLimit)
+	counter("MemoryStats.Stats.TotalPgfault", UnitCount, stats.MemoryStats.Stats.TotalPgfault)
+	gauge("MemoryStats.Stats.TotalActiveFile", UnitBytes, stats.MemoryStats.Stats.TotalActiveFile)
+	gauge("MemoryStats.Stats.ActiveAnon", UnitBytes, stats.MemoryStats.Stats.ActiveAnon)
+	gauge("MemoryStats.Stats.TotalActiveAnon", UnitBytes, stats.MemoryStats.Stats.TotalActiveAnon)
+	counter("MemoryStats.Stats.TotalPgpgout", UnitPages, stats.MemoryStats.Stats.TotalPgpgout)
+	gauge("MemoryStats.Stats.TotalCache", UnitBytes, stats.MemoryStats.Stats.TotalCache)
+	gauge("MemoryStats.Stats.InactiveAnon", UnitBytes, stats.MemoryStats.Stats.InactiveAnon)
+	gauge("MemoryStats.Stats.ActiveFile", UnitBytes, stats.MemoryStats.Stats.ActiveFile)
+	counter("MemoryStats.Stats.Pgfault", UnitCount, stats.MemoryStats.Stats.Pgfault)
+	gauge("MemoryStats.Stats.InactiveFile", UnitBytes, stats.MemoryStats.Stats.InactiveFile)
+	counter("MemoryStats.Stats.TotalPgpgin", UnitPages, stats.MemoryStats.Stats.TotalPgpgin)
+	gauge("MemoryStats.MaxUsage", UnitBytes, stats.MemoryStats.MaxUsage)
+	gauge("MemoryStats.Usage", UnitBytes, stats.MemoryStats.Usage)
+	counter("MemoryStats.Failcnt", UnitCount, stats.MemoryStats.Failcnt)
+	gauge("MemoryStats.Limit", UnitBytes, stats.MemoryStats.Limit)
 
 	// CPUStats
 	for i, v := range stats.CPUStats.CPUUsage.PercpuUsage {
-		sample(fmt.Sprintf("CPUStats.CPUUsage.PercpuUsage.%d", i), v)
+		fn(Metric{
+			Name:   fmt.Sprintf("CPUStats.CPUUsage.PercpuUsage.%d", i),
+			Value:  float64(v),
+			Kind:   Counter,
+			Unit:   UnitNanoseconds,
+			Tags:   map[string]string{"cpu": strconv.Itoa(i)},
+			Family: "CPUStats.CPUUsage.PercpuUsage",
+		})
+	}
+	counter("CPUStats.CPUUsage.UsageInUsermode", UnitNanoseconds, stats.CPUStats.CPUUsage.UsageInUsermode)
+	counter("CPUStats.CPUUsage.TotalUsage", UnitNanoseconds, stats.CPUStats.CPUUsage.TotalUsage)
+	counter("CPUStats.CPUUsage.UsageInKernelmode", UnitNanoseconds, stats.CPUStats.CPUUsage.UsageInKernelmode)
+	counter("CPUStats.SystemCPUUsage", UnitNanoseconds, stats.CPUStats.SystemCPUUsage)
+	counter("CPUStats.ThrottlingData.Periods", UnitCount, stats.CPUStats.ThrottlingData.Periods)
+	counter("CPUStats.ThrottlingData.ThrottledPeriods", UnitCount, stats.CPUStats.ThrottlingData.ThrottledPeriods)
+	counter("CPUStats.ThrottlingData.ThrottledTime", UnitNanoseconds, stats.CPUStats.ThrottlingData.ThrottledTime)
+
+	// BlkioStats
+	blkio := func(name string, kind Kind, unit string, entries []docker.BlkioStatsEntry) {
+		for _, e := range entries {
+			tags := map[string]string{"device": fmt.Sprintf("%d:%d", e.Major, e.Minor)}
+			if e.Op != "" {
+				tags["op"] = e.Op
+			}
+
+			fn(Metric{
+				Name:   blkioName(name, e),
+				Value:  float64(e.Value),
+				Kind:   kind,
+				Unit:   unit,
+				Tags:   tags,
+				Family: name,
+			})
+		}
 	}
-	sample("CPUStats.CPUUsage.UsageInUsermode", stats.CPUStats.CPUUsage.UsageInUsermode)
-	sample("CPUStats.CPUUsage.TotalUsage", stats.CPUStats.CPUUsage.TotalUsage)
-	sample("CPUStats.CPUUsage.UsageInKernelmode", stats.CPUStats.CPUUsage.UsageInKernelmode)
-	sample("CPUStats.SystemCPUUsage", stats.CPUStats.SystemCPUUsage)
-	sample("CPUStats.ThrottlingData.Periods", stats.CPUStats.ThrottlingData.Periods)
-	sample("CPUStats.ThrottlingData.ThrottledPeriods", stats.CPUStats.ThrottlingData.ThrottledPeriods)
-	sample("CPUStats.ThrottlingData.ThrottledTime", stats.CPUStats.ThrottlingData.ThrottledTime)
+	blkio("BlkioStats.IOServiceBytesRecursive", Counter, UnitBytes, stats.BlkioStats.IOServiceBytesRecursive)
+	blkio("BlkioStats.IOServicedRecursive", Counter, UnitCount, stats.BlkioStats.IOServicedRecursive)
+	blkio("BlkioStats.IOQueueRecursive", Gauge, UnitCount, stats.BlkioStats.IOQueueRecursive)
+	blkio("BlkioStats.IOServiceTimeRecursive", Counter, UnitNanoseconds, stats.BlkioStats.IOServiceTimeRecursive)
+	blkio("BlkioStats.IOWaitTimeRecursive", Counter, UnitNanoseconds, stats.BlkioStats.IOWaitTimeRecursive)
+	blkio("BlkioStats.IOMergedRecursive", Counter, UnitCount, stats.BlkioStats.IOMergedRecursive)
+	blkio("BlkioStats.IOTimeRecursive", Counter, UnitMilliseconds, stats.BlkioStats.IOTimeRecursive)
+	blkio("BlkioStats.SectorsRecursive", Counter, UnitSectors, stats.BlkioStats.SectorsRecursive)
 }
 
-func (s *Stat) event(container *docker.Container, event *docker.APIEvents) {
-	s.adapter().Incr(container, fmt.Sprintf("Container.%s", strings.Title(event.Status)), 1)
+// blkioName returns the metric name for a blkio entry. The device is encoded
+// as `<major>_<minor>` so that the name doesn't contain characters that are
+// significant to the statsd wire format, followed by the operation if there is
+// one (e.g. BlkioStats.IOServiceBytesRecursive.8_0.Read).
+func blkioName(prefix string, e docker.BlkioStatsEntry) string {
+	name := fmt.Sprintf("%s.%d_%d", prefix, e.Major, e.Minor)
+	if e.Op != "" {
+		name += "." + e.Op
+	}
+	return name
 }
 
-func (s *Stat) adapter() Adapter {
-	if s.Adapter == nil {
-		return DefaultAdapter
+// swapPrevious stores stats as the latest sample for the container and returns
+// the sample that it replaced, if any.
+func (s *Stat) swapPrevious(containerID string, stats *docker.Stats) *docker.Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.previous == nil {
+		s.previous = make(map[string]*docker.Stats)
 	}
 
-	return s.Adapter
+	prev := s.previous[containerID]
+	s.previous[containerID] = stats
+	return prev
 }
 
-func (s *Stat) whitelisted(name string) bool {
-	if len(s.Whitelist) == 0 {
-		return true
+// setLatestSample stores stats as the last sample that was received for the
+// container.
+func (s *Stat) setLatestSample(containerID string, stats *docker.Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.latest == nil {
+		s.latest = make(map[string]*docker.Stats)
+	}
+	s.latest[containerID] = stats
+}
+
+// latestSample returns the last sample that was received for the container, if
+// any.
+func (s *Stat) latestSample(containerID string) *docker.Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.latest[containerID]
+}
+
+// rates returns the rate state for the container.
+func (s *Stat) rates(containerID string) *rates {
+	r := s.resolutionFor(containerID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.counters == nil {
+		s.counters = make(map[string]*rates)
+	}
+
+	c, ok := s.counters[containerID]
+	if !ok {
+		// Allow a couple of ticks to be missed before treating the
+		// counters as discontinuous.
+		c = newRates(3 * resolution(r))
+		s.counters[containerID] = c
 	}
+	return c
+}
+
+func (s *Stat) event(container *docker.Container, event *dockerEvent) {
+	defer func() {
+		if v := recover(); v != nil {
+			debug("recovered panic in event: %v", v)
+		}
+	}()
+
+	t := time.Unix(event.Time, 0)
 
-	for _, pattern := range s.Whitelist {
-		if ok, _ := glob.Match(pattern, name); ok {
+	metrics := []*Metric{{
+		Container: container,
+		Name:      fmt.Sprintf("Container.%s", strings.Title(event.Status)),
+		Value:     1,
+		Kind:      Event,
+		Time:      t,
+	}}
+
+	switch event.Status {
+	case "die":
+		metrics = append(metrics, s.died(container, metrics[0], t)...)
+	case "oom":
+		metrics = append(metrics, oom(container, event.stats, t)...)
+	case "kill":
+		// The signal is an attribute of the kill event in newer
+		// versions of the API.
+		if signal := event.Actor.Attributes["signal"]; signal != "" {
+			metrics[0].Tags = map[string]string{"signal": signal}
+		}
+	}
+
+	s.drain(metrics)
+}
+
+// died adds the exit code of the container to the die event, from a fresh
+// inspect since the cached container has the state from when it was first
+// seen. It returns the exit code and how long the container was running for.
+func (s *Stat) died(container *docker.Container, event *Metric, t time.Time) []*Metric {
+	var metrics []*Metric
+
+	state := container.State
+	if c, err := s.client.InspectContainer(container.ID); err != nil {
+		debug("inspect: err: %s", err)
+	} else {
+		state = c.State
+
+		event.Tags = map[string]string{"exit_code": strconv.Itoa(state.ExitCode)}
+
+		// By convention, an exit code above 128 means that the
+		// process was killed by the signal 128 less.
+		if state.ExitCode > 128 {
+			event.Tags["signal"] = strconv.Itoa(state.ExitCode - 128)
+		}
+
+		metrics = append(metrics, &Metric{
+			Container: container,
+			Name:      "Container.ExitCode",
+			Value:     float64(state.ExitCode),
+			Kind:      Gauge,
+			Time:      t,
+		})
+	}
+
+	// Also emit how long it was running for.
+	if state.StartedAt.Before(t) && !state.StartedAt.IsZero() {
+		metrics = append(metrics, &Metric{
+			Container: container,
+			Name:      "Container.Uptime",
+			Value:     float64(t.Sub(state.StartedAt)),
+			Kind:      Timing,
+			Unit:      UnitNanoseconds,
+			Time:      t,
+		})
+	}
+
+	return metrics
+}
+
+// oom returns the memory usage and limit of the container from the latest
+// stats sample that was received when it ran out of memory.
+func oom(container *docker.Container, stats *docker.Stats, t time.Time) []*Metric {
+	if stats == nil {
+		return nil
+	}
+
+	gauge := func(name string, value uint64) *Metric {
+		return &Metric{
+			Container: container,
+			Name:      name,
+			Value:     float64(value),
+			Kind:      Gauge,
+			Unit:      UnitBytes,
+			Time:      t,
+		}
+	}
+
+	return []*Metric{
+		gauge("Container.Oom.MemoryStats.Usage", stats.MemoryStats.Usage),
+		gauge("Container.Oom.MemoryStats.Limit", stats.MemoryStats.Limit),
+	}
+}
+
+// emits reports whether the docker event is emitted.
+func (s *Stat) emits(status string) bool {
+	events := s.Events
+	if len(events) == 0 {
+		events = DefaultEvents
+	}
+
+	for _, e := range events {
+		if e == status {
 			return true
 		}
 	}
@@ -274,12 +1107,156 @@ func (s *Stat) whitelisted(name string) bool {
 	return false
 }
 
-func newTicker(resolution int) *time.Ticker {
-	if resolution == 0 {
-		resolution = DefaultResolution
+// drain drains the metrics to the adapter, as a single batch if the adapter is
+// a BatchAdapter.
+func (s *Stat) drain(metrics []*Metric) {
+	if len(metrics) == 0 {
+		return
+	}
+
+	a := s.adapter()
+
+	if b, ok := a.(BatchAdapter); ok {
+		b.Batch(metrics)
+		return
+	}
+
+	for _, m := range metrics {
+		a.Emit(m)
+	}
+}
+
+// tick emits internal metrics and flushes the adapter, if it's a BatchAdapter,
+// once per resolution until done is closed.
+func (s *Stat) tick(done <-chan struct{}) {
+	ticker := newTicker(s.Resolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.internal()
+			s.flush()
+		case <-done:
+			s.flush()
+			return
+		}
+	}
+}
+
+// internal drains the internal metrics about dockerstats itself.
+func (s *Stat) internal() {
+	var metrics []*Metric
+
+	t := time.Now()
+	filter := s.metricFilter(InternalContainer.ID)
+	walkInternal(func(m Metric) {
+		if filter.Match(m.Name) {
+			m.Container = InternalContainer
+			m.Time = t
+			metrics = append(metrics, &m)
+		}
+	})
+
+	s.drain(metrics)
+}
+
+// flush flushes the adapter, if it's a BatchAdapter.
+func (s *Stat) flush() {
+	if b, ok := s.adapter().(BatchAdapter); ok {
+		if err := b.Flush(); err != nil {
+			debug("flush: err: %s", err)
+		}
+	}
+}
+
+func (s *Stat) adapter() AdapterV2 {
+	if s.Adapter == nil {
+		return AsV2(DefaultAdapter)
+	}
+
+	return AsV2(s.Adapter)
+}
+
+// metricFilter returns the filter that decides which stats are drained for
+// the container, from the Whitelist and Blacklist, or the whitelist of the
+// container if it overrides it.
+func (s *Stat) metricFilter(containerID string) *metricFilter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.metrics == nil {
+		f, err := newMetricFilter(s.Whitelist, s.Blacklist)
+		if err != nil {
+			// Run validates the patterns before any stats are
+			// drained, so this only happens when Run wasn't called.
+			debug("metric filter: err: %s", err)
+			f, _ = newMetricFilter(nil, nil)
+		}
+		s.metrics = f
+	}
+
+	o, ok := s.overrides[containerID]
+	if !ok || o.whitelist == nil {
+		return s.metrics
+	}
+
+	if o.filter == nil {
+		f, err := newMetricFilter(o.whitelist, s.Blacklist)
+		if err != nil {
+			debug("%s: invalid %s label: %s", containerID, WhitelistLabel, err)
+			f = s.metrics
+		}
+		o.filter = f
+	}
+
+	return o.filter
+}
+
+// collected reports whether metrics and events should be collected from the
+// container, according to the Include and Exclude filters and the
+// EnableLabel of the container.
+func (s *Stat) collected(container *docker.Container) bool {
+	if s.overridesFor(container.ID).disabled {
+		return false
+	}
+
+	return s.filters == nil || s.filters.Match(container)
+}
+
+// overridesFor returns the overrides of the container.
+func (s *Stat) overridesFor(containerID string) *overrides {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o, ok := s.overrides[containerID]; ok {
+		return o
+	}
+	return &overrides{}
+}
+
+// resolutionFor returns the resolution of the container, in seconds, which is
+// the Resolution unless the container overrides it. It's 0 when the default
+// resolution is used.
+func (s *Stat) resolutionFor(containerID string) int {
+	if o := s.overridesFor(containerID); o.resolution != 0 {
+		return o.resolution
+	}
+	return s.Resolution
+}
+
+func newTicker(r int) *time.Ticker {
+	return time.NewTicker(resolution(r))
+}
+
+// resolution returns the resolution in seconds as a time.Duration, falling
+// back to DefaultResolution.
+func resolution(r int) time.Duration {
+	if r == 0 {
+		r = DefaultResolution
 	}
 
-	return time.NewTicker(time.Duration(resolution) * time.Second)
+	return time.Duration(r) * time.Second
 }
 
 func debug(format string, v ...interface{}) {
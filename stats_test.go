@@ -0,0 +1,870 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dockertest "github.com/fsouza/go-dockerclient/testing"
+)
+
+type fakeBatchAdapter struct {
+	mu        sync.Mutex
+	Batches   [][]*Metric
+	Flushes   int
+	Forgotten []string
+}
+
+func (a *fakeBatchAdapter) Emit(m *Metric) {
+	a.Batch([]*Metric{m})
+}
+
+func (a *fakeBatchAdapter) Batch(metrics []*Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Batches = append(a.Batches, metrics)
+}
+
+func (a *fakeBatchAdapter) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Flushes++
+	return nil
+}
+
+func (a *fakeBatchAdapter) Forget(containerID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Forgotten = append(a.Forgotten, containerID)
+}
+
+// names returns the names of all of the metrics that were drained.
+func (a *fakeBatchAdapter) names() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var names []string
+	for _, batch := range a.Batches {
+		for _, m := range batch {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
+
+func TestStat_BatchAdapter(t *testing.T) {
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter:   AsV1(b),
+		Whitelist: []string{"MemoryStats.*"},
+	}
+
+	c := &docker.Container{ID: "abcd", Name: "dummy"}
+	stats := new(docker.Stats)
+	stats.MemoryStats.Usage = 1024
+
+	s.stats(c, stats, nil)
+	s.stats(c, stats, nil)
+
+	if got, want := len(b.Batches), 2; got != want {
+		t.Fatalf("len(Batches) => %d; want %d", got, want)
+	}
+
+	for _, m := range b.Batches[0] {
+		if m.Container != c {
+			t.Errorf("Container => %v; want %v", m.Container, c)
+		}
+		if m.Name == "MemoryStats.Usage" && m.Value != 1024 {
+			t.Errorf("MemoryStats.Usage => %v; want %v", m.Value, 1024)
+		}
+	}
+
+	// Batches aren't flushed until the next tick.
+	if got, want := b.Flushes, 0; got != want {
+		t.Errorf("Flushes => %d; want %d", got, want)
+	}
+}
+
+func TestStat_BatchAdapter_Flush(t *testing.T) {
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter:    AsV1(b),
+		Resolution: 1,
+		Whitelist:  []string{"Internal.*"},
+	}
+
+	flushes := func() int {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.Flushes
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		s.tick(done)
+		close(finished)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for flushes() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a flush")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The adapter is flushed once per tick, after the internal metrics are
+	// drained as a single batch.
+	b.mu.Lock()
+	batches, n := len(b.Batches), b.Flushes
+	b.mu.Unlock()
+	if got, want := n, 1; got != want {
+		t.Errorf("Flushes => %d; want %d", got, want)
+	}
+	if got, want := batches, 1; got != want {
+		t.Errorf("len(Batches) => %d; want %d", got, want)
+	}
+
+	// It's flushed once more when it stops.
+	close(done)
+	<-finished
+	if got, want := flushes(), 2; got != want {
+		t.Errorf("Flushes => %d; want %d", got, want)
+	}
+}
+
+func TestStat_Aggregate_Whitelist(t *testing.T) {
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter:   AsV1(b),
+		Whitelist: []string{"MemoryStats.Usage"},
+	}
+
+	c := &docker.Container{ID: "abcd", Name: "dummy"}
+	stats := new(docker.Stats)
+	stats.MemoryStats.Usage = 1024
+
+	w := newWindow()
+	w.add(stats)
+	s.stats(c, stats, w)
+
+	want := []string{
+		"MemoryStats.Usage.min",
+		"MemoryStats.Usage.max",
+		"MemoryStats.Usage.mean",
+		"MemoryStats.Usage.last",
+	}
+	if got := b.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("names => %v; want %v", got, want)
+	}
+}
+
+func TestWalk_Blkio(t *testing.T) {
+	stats := new(docker.Stats)
+	stats.BlkioStats.IOServiceBytesRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 512},
+		{Major: 8, Minor: 0, Op: "Write", Value: 1024},
+	}
+	stats.BlkioStats.IOQueueRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Minor: 16, Op: "Total", Value: 2},
+	}
+	stats.BlkioStats.SectorsRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Minor: 0, Value: 64},
+	}
+
+	type metric struct {
+		Name   string
+		Value  float64
+		Kind   Kind
+		Unit   string
+		Tags   map[string]string
+		Family string
+	}
+	var got []metric
+	walk(stats, func(m Metric) {
+		if strings.HasPrefix(m.Name, "BlkioStats.") {
+			got = append(got, metric{m.Name, m.Value, m.Kind, m.Unit, m.Tags, m.Family})
+		}
+	})
+
+	want := []metric{
+		{"BlkioStats.IOServiceBytesRecursive.8_0.Read", 512, Counter, UnitBytes, map[string]string{"device": "8:0", "op": "Read"}, "BlkioStats.IOServiceBytesRecursive"},
+		{"BlkioStats.IOServiceBytesRecursive.8_0.Write", 1024, Counter, UnitBytes, map[string]string{"device": "8:0", "op": "Write"}, "BlkioStats.IOServiceBytesRecursive"},
+		{"BlkioStats.IOQueueRecursive.8_16.Total", 2, Gauge, UnitCount, map[string]string{"device": "8:16", "op": "Total"}, "BlkioStats.IOQueueRecursive"},
+		{"BlkioStats.SectorsRecursive.8_0", 64, Counter, UnitSectors, map[string]string{"device": "8:0"}, "BlkioStats.SectorsRecursive"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walk() => %v; want %v", got, want)
+	}
+}
+
+func TestStat_Overrides(t *testing.T) {
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter:   AsV1(b),
+		Whitelist: []string{"MemoryStats.*"},
+	}
+
+	web := &docker.Container{
+		ID:   "abcd",
+		Name: "web",
+		Config: &docker.Config{
+			Labels: map[string]string{WhitelistLabel: "Network.RxBytes"},
+		},
+	}
+	worker := &docker.Container{
+		ID:   "efgh",
+		Name: "worker",
+		Config: &docker.Config{
+			Labels: map[string]string{EnableLabel: "false"},
+		},
+	}
+	s.overrides = map[string]*overrides{
+		web.ID:    parseOverrides(web),
+		worker.ID: parseOverrides(worker),
+	}
+
+	if !s.collected(web) {
+		t.Errorf("collected(web) => false; want true")
+	}
+	if s.collected(worker) {
+		t.Errorf("collected(worker) => true; want false")
+	}
+
+	stats := new(docker.Stats)
+	stats.MemoryStats.Usage = 1024
+	stats.Network.RxBytes = 10
+
+	s.stats(web, stats, nil)
+
+	var names []string
+	for _, m := range b.Batches[0] {
+		names = append(names, m.Name)
+	}
+	if got, want := names, []string{"Network.RxBytes"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("names => %v; want %v", got, want)
+	}
+}
+
+func TestStat_Rates_Resolution(t *testing.T) {
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter:    AsV1(b),
+		Rates:      true,
+		Resolution: 10,
+		Whitelist:  []string{"Network.RxBytes*"},
+	}
+
+	// The container is sampled every 40 seconds, which is more than 3
+	// ticks of the default resolution.
+	c := &docker.Container{
+		ID:   "abcd",
+		Name: "web",
+		Config: &docker.Config{
+			Labels: map[string]string{ResolutionLabel: "40"},
+		},
+	}
+	s.overrides = map[string]*overrides{c.ID: parseOverrides(c)}
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		stats := new(docker.Stats)
+		stats.Read = now.Add(time.Duration(i) * 40 * time.Second)
+		stats.Network.RxBytes = uint64(i) * 400
+		s.stats(c, stats, nil)
+	}
+
+	var rate *Metric
+	for _, m := range b.Batches[1] {
+		if m.Name == "Network.RxBytes.Rate" {
+			rate = m
+		}
+	}
+	if rate == nil {
+		t.Fatal("Network.RxBytes.Rate => nil; want a rate")
+	}
+	if got, want := rate.Value, float64(10); got != want {
+		t.Errorf("Network.RxBytes.Rate => %v; want %v", got, want)
+	}
+}
+
+// newTestServer returns a docker client for a fake docker server, along with a
+// container that was created on it.
+func newTestServer(t *testing.T) (*dockertest.DockerServer, *docker.Client, *docker.Container) {
+	server, err := dockertest.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := docker.NewClient(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.PullImage(docker.PullImageOptions{Repository: "acme-inc"}, docker.AuthConfiguration{}); err != nil {
+		t.Fatal(err)
+	}
+
+	container, err := client.CreateContainer(docker.CreateContainerOptions{
+		Name:   "web",
+		Config: &docker.Config{Image: "acme-inc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, client, container
+}
+
+func TestStat_Event(t *testing.T) {
+	server, client, container := newTestServer(t)
+	defer server.Stop()
+
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter: AsV1(b),
+		client:  client,
+	}
+
+	started := time.Unix(100, 0)
+	if err := server.MutateContainer(container.ID, docker.State{StartedAt: started, ExitCode: 137}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := new(docker.Stats)
+	stats.MemoryStats.Usage = 1024
+	stats.MemoryStats.Limit = 2048
+
+	kill := &dockerEvent{APIEvents: docker.APIEvents{Status: "kill", ID: container.ID, Time: 110}}
+	kill.Actor.Attributes = map[string]string{"signal": "9"}
+
+	s.event(container, &dockerEvent{APIEvents: docker.APIEvents{Status: "oom", ID: container.ID, Time: 110}, stats: stats})
+	s.event(container, kill)
+	s.event(container, &dockerEvent{APIEvents: docker.APIEvents{Status: "die", ID: container.ID, Time: 110}})
+
+	type metric struct {
+		Name  string
+		Value float64
+		Tags  map[string]string
+	}
+	var got [][]metric
+	for _, batch := range b.Batches {
+		var metrics []metric
+		for _, m := range batch {
+			metrics = append(metrics, metric{m.Name, m.Value, m.Tags})
+		}
+		got = append(got, metrics)
+	}
+
+	want := [][]metric{
+		{
+			{"Container.Oom", 1, nil},
+			{"Container.Oom.MemoryStats.Usage", 1024, nil},
+			{"Container.Oom.MemoryStats.Limit", 2048, nil},
+		},
+		{
+			{"Container.Kill", 1, map[string]string{"signal": "9"}},
+		},
+		{
+			{"Container.Die", 1, map[string]string{"exit_code": "137", "signal": "9"}},
+			{"Container.ExitCode", 137, nil},
+			{"Container.Uptime", float64(10 * time.Second), nil},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("event() => %v; want %v", got, want)
+	}
+}
+
+func TestStat_Oom(t *testing.T) {
+	server, client, web := newTestServer(t)
+	defer server.Stop()
+
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter: AsV1(b),
+		Events:  []string{"oom"},
+		client:  client,
+	}
+
+	if _, err := s.addContainer(web.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := new(docker.Stats)
+	stats.MemoryStats.Usage = 2048
+	s.setLatestSample(web.ID, stats)
+
+	// The sample is read when the event is handled, so it's emitted even
+	// if the container is forgotten right after.
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "oom", ID: web.ID, Time: 110}})
+	s.removeContainer(web.ID)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		var usage *Metric
+		for _, batch := range b.Batches {
+			for _, m := range batch {
+				if m.Name == "Container.Oom.MemoryStats.Usage" {
+					usage = m
+				}
+			}
+		}
+		n := len(b.Batches)
+		b.mu.Unlock()
+
+		if usage != nil {
+			if got, want := usage.Value, float64(2048); got != want {
+				t.Errorf("Container.Oom.MemoryStats.Usage => %v; want %v", got, want)
+			}
+			break
+		}
+		if n > 0 {
+			t.Fatal("Container.Oom.MemoryStats.Usage => nil; want a sample")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the oom event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStat_Emits(t *testing.T) {
+	s := &Stat{}
+	if !s.emits("die") {
+		t.Error("emits(die) => false; want true")
+	}
+
+	s.Events = []string{"oom"}
+	if s.emits("die") {
+		t.Error("emits(die) => true; want false")
+	}
+	if !s.emits("oom") {
+		t.Error("emits(oom) => false; want true")
+	}
+}
+
+func TestStat_RemoveContainer(t *testing.T) {
+	server, client, web := newTestServer(t)
+	defer server.Stop()
+
+	worker, err := client.CreateContainer(docker.CreateContainerOptions{
+		Name:   "worker",
+		Config: &docker.Config{Image: "acme-inc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter:    AsV1(b),
+		client:     client,
+		containers: make(map[string]*docker.Container),
+	}
+
+	for _, id := range []string{web.ID, worker.ID} {
+		if _, err := s.addContainer(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := atomic.LoadUint64(&trackedContainers.value), uint64(2); got != want {
+		t.Errorf("tracked => %d; want %d", got, want)
+	}
+
+	// The container is removed after the destroy event is emitted.
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "destroy", ID: web.ID, Time: 110}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.tracked() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the container to be removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got, want := b.Batches[0][0].Name, "Container.Destroy"; got != want {
+		t.Errorf("Name => %q; want %q", got, want)
+	}
+
+	// Containers that were removed without an event are removed when the
+	// containers are reconciled.
+	if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: worker.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.reconcileContainers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := s.tracked(), 0; got != want {
+		t.Errorf("tracked => %d; want %d", got, want)
+	}
+	if got, want := atomic.LoadUint64(&trackedContainers.value), uint64(0); got != want {
+		t.Errorf("tracked => %d; want %d", got, want)
+	}
+
+	// The adapter is told to forget both containers.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if got, want := b.Forgotten, []string{web.ID, worker.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Forgotten => %v; want %v", got, want)
+	}
+}
+
+func TestStat_RemoveContainer_NotEmitted(t *testing.T) {
+	server, client, web := newTestServer(t)
+	defer server.Stop()
+
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter: AsV1(b),
+		Events:  []string{"start"},
+		client:  client,
+	}
+
+	if _, err := s.addContainer(web.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "destroy", ID: web.ID, Time: 110}})
+
+	// A container that was never known, and can't be inspected.
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "destroy", ID: "unknown", Time: 110}})
+
+	forgotten := func() []string {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return append([]string(nil), b.Forgotten...)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(forgotten()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Forgotten => %v; want 2 containers", forgotten())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	got := forgotten()
+	sort.Strings(got)
+	want := []string{web.ID, "unknown"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Forgotten => %v; want %v", got, want)
+	}
+	if names := b.names(); len(names) != 0 {
+		t.Errorf("names => %v; want none", names)
+	}
+}
+
+// tracked returns the number of known containers.
+func (s *Stat) tracked() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.containers)
+}
+
+func TestStat_RefreshContainer(t *testing.T) {
+	server, client, web := newTestServer(t)
+	defer server.Stop()
+
+	s := &Stat{
+		Adapter: AsV1(&fakeBatchAdapter{}),
+		client:  client,
+		Events:  []string{"die"},
+	}
+
+	before, err := s.addContainer(web.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RenameContainer(docker.RenameContainerOptions{ID: web.ID, Name: "api"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renamed containers are refreshed, even if the event isn't emitted.
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "rename", ID: web.ID, Time: 110}})
+
+	if got, want := s.container(web.ID).Name, "api"; got != want {
+		t.Errorf("Name => %q; want %q", got, want)
+	}
+
+	// The cached container is replaced, not changed.
+	if got, want := before.Name, "web"; got != want {
+		t.Errorf("Name => %q; want %q", got, want)
+	}
+}
+
+// newTestAPI returns a dockerAPI for the fake docker server.
+func newTestAPI(t *testing.T, server *dockertest.DockerServer) *dockerAPI {
+	api, err := newDockerAPI(server.URL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+// serveStats makes the fake docker server, which doesn't serve stats, stream a
+// sample of the container every 10ms until the client disconnects. It returns
+// counters of the streams that were opened, and that are still open.
+func serveStats(server *dockertest.DockerServer, containerID string) (opened, open *int32) {
+	opened, open = new(int32), new(int32)
+	server.CustomHandler("/containers/"+containerID+"/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(opened, 1)
+		atomic.AddInt32(open, 1)
+		defer atomic.AddInt32(open, -1)
+
+		stats := new(docker.Stats)
+		stats.MemoryStats.Usage = 1024
+		for {
+			if err := json.NewEncoder(w).Encode(stats); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	return opened, open
+}
+
+// waitFor fails the test if cond isn't true within 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStat_Collectors(t *testing.T) {
+	server, client, web := newTestServer(t)
+	defer server.Stop()
+
+	streams, _ := serveStats(server, web.ID)
+
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter:    AsV1(b),
+		Resolution: 1,
+		Whitelist:  []string{"MemoryStats.Usage"},
+		client:     client,
+		api:        newTestAPI(t, server),
+	}
+
+	hasStreams := func(n int32) func() bool {
+		return func() bool { return atomic.LoadInt32(streams) == n }
+	}
+
+	if _, err := s.addContainer(web.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Starting a collector for a container that's already being collected
+	// from reuses the stream.
+	c := s.startCollector(web)
+	if got := s.startCollector(web); got != c {
+		t.Errorf("startCollector() => %p; want %p", got, c)
+	}
+	waitFor(t, "a stream", hasStreams(1))
+	waitFor(t, "a sample", func() bool {
+		for _, name := range b.names() {
+			if name == "MemoryStats.Usage" {
+				return true
+			}
+		}
+		return false
+	})
+
+	// Dying stops the collector, and starting again opens a new stream.
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "die", ID: web.ID, Time: 110}})
+	<-c.done
+
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "start", ID: web.ID, Time: 111}})
+	waitFor(t, "a new stream", hasStreams(2))
+
+	// The restart event that follows the start event doesn't open another
+	// stream.
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "restart", ID: web.ID, Time: 111}})
+	time.Sleep(50 * time.Millisecond)
+	if got, want := atomic.LoadInt32(streams), int32(2); got != want {
+		t.Errorf("streams => %d; want %d", got, want)
+	}
+
+	// Destroying the container stops the collector.
+	s.mu.Lock()
+	c = s.collectors[web.ID]
+	s.mu.Unlock()
+	if c == nil {
+		t.Fatal("collector => nil; want a collector")
+	}
+
+	s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "destroy", ID: web.ID, Time: 112}})
+	<-c.done
+
+	s.mu.Lock()
+	n := len(s.collectors)
+	s.mu.Unlock()
+	if n != 0 {
+		t.Errorf("len(collectors) => %d; want 0", n)
+	}
+}
+
+func TestStat_Collectors_Restart(t *testing.T) {
+	server, client, web := newTestServer(t)
+	defer server.Stop()
+
+	opened, open := serveStats(server, web.ID)
+
+	s := &Stat{
+		Adapter: AsV1(&fakeBatchAdapter{}),
+		Events:  []string{"start", "die"},
+		client:  client,
+		api:     newTestAPI(t, server),
+	}
+
+	// Each time the container dies, its stream is closed, so restarting it
+	// doesn't leave the previous streams open.
+	for i := int32(1); i <= 5; i++ {
+		s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "start", ID: web.ID, Time: 100}})
+		waitFor(t, "a stream", func() bool { return atomic.LoadInt32(opened) == i })
+
+		s.handle(&dockerEvent{APIEvents: docker.APIEvents{Status: "die", ID: web.ID, Time: 100}})
+		waitFor(t, "the stream to be closed", func() bool { return atomic.LoadInt32(open) == 0 })
+	}
+}
+
+func TestStat_Reconnect(t *testing.T) {
+	server, client, web := newTestServer(t)
+	defer server.Stop()
+
+	if err := server.MutateContainer(web.ID, docker.State{Running: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lists int32
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lists, 1)
+		server.DefaultHandler().ServeHTTP(w, r)
+	}))
+
+	// Stream until the test ends, or the client disconnects.
+	closed := make(chan struct{})
+	defer close(closed)
+
+	since := make(chan string, 1)
+	var connections int32
+	server.CustomHandler("/events", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		start := &docker.APIEvents{Status: "start", ID: web.ID, From: "acme-inc", Time: 100}
+
+		if atomic.AddInt32(&connections, 1) == 1 {
+			// The daemon closes the stream after the first event.
+			e.Encode(start)
+			return
+		}
+
+		// The stream is resumed from the last event, so the start event
+		// is replayed.
+		since <- r.URL.Query().Get("since")
+		e.Encode(start)
+		e.Encode(&docker.APIEvents{Status: "die", ID: web.ID, From: "acme-inc", Time: 101})
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+		case <-closed:
+		}
+	}))
+
+	b := &fakeBatchAdapter{}
+	s := &Stat{
+		Adapter: AsV1(b),
+		Events:  []string{"start", "die"},
+		client:  client,
+		api:     newTestAPI(t, server),
+	}
+
+	backoff := ReconnectBackoff
+	ReconnectBackoff = time.Millisecond
+
+	var runErr error
+	finished := make(chan struct{})
+	go func() {
+		runErr = s.Run()
+		close(finished)
+	}()
+
+	// ReconnectBackoff is only restored once Run has returned.
+	defer func() {
+		s.Stop()
+		<-finished
+		ReconnectBackoff = backoff
+	}()
+
+	select {
+	case got := <-since:
+		if want := "100"; got != want {
+			t.Errorf("since => %q; want %q", got, want)
+		}
+	case <-finished:
+		t.Fatalf("Run() => %v", runErr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reconnect")
+	}
+
+	count := func(name string) (n int) {
+		for _, got := range b.names() {
+			if got == name {
+				n++
+			}
+		}
+		return n
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for count("Container.Die") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the die event")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got, want := count("Container.Start"), 1; got != want {
+		t.Errorf("Container.Start => %d; want %d", got, want)
+	}
+
+	// The containers are listed when Run starts, and again after
+	// reconnecting.
+	if got, want := atomic.LoadInt32(&lists), int32(2); got < want {
+		t.Errorf("ListContainers => %d; want at least %d", got, want)
+	}
+
+	s.Stop()
+	select {
+	case <-finished:
+		if runErr != nil {
+			t.Errorf("Run() => %v; want nil", runErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+}
@@ -0,0 +1,267 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default sizes of the buffer used to pack metrics into a single write, for
+// each network.
+var (
+	// StatsdUDPBufferSize keeps datagrams within a typical ethernet MTU.
+	StatsdUDPBufferSize = 1432
+
+	// StatsdUnixBufferSize and StatsdTCPBufferSize aren't limited by the
+	// MTU, so they're larger.
+	StatsdUnixBufferSize = 8192
+	StatsdTCPBufferSize  = 8192
+)
+
+// StatsdBackoff and MaxStatsdBackoff bound how long a StatsdConn waits before
+// connecting again, after it failed to connect to statsd. Metrics are dropped,
+// and counted as send failures, while it waits. The wait is doubled after each
+// failed attempt.
+var (
+	StatsdBackoff    = time.Second
+	MaxStatsdBackoff = 30 * time.Second
+)
+
+// errStatsdBackoff is returned when metrics are dropped because the StatsdConn
+// is waiting to connect to statsd again.
+var errStatsdBackoff = errors.New("statsd: waiting to reconnect")
+
+// statsdSendFailures counts the metrics that could not be sent to statsd.
+var statsdSendFailures = newInternalCounter("Internal.Statsd.SendFailures")
+
+// StatsdConn is a TaggedStatsdClient that sends metrics to statsd over udp, tcp
+// or a unix datagram socket. Tags are sent in the DogStatsD format.
+//
+// When buffered, metrics are packed into writes of up to the buffer size,
+// separated by newlines, and a write is made when the next metric wouldn't fit
+// or the StatsdConn is flushed.
+type StatsdConn struct {
+	w    *statsdWriter
+	tags []string
+}
+
+// NewStatsdConn returns a new StatsdConn that sends metrics to addr on the
+// network, which can be "udp", "tcp" or "unixgram". If buffered is true,
+// metrics are packed into writes of up to the default buffer size for the
+// network. The connection is established lazily, and re-established after
+// failures.
+func NewStatsdConn(network, addr string, buffered bool) (*StatsdConn, error) {
+	var size int
+	switch network {
+	case "udp":
+		size = StatsdUDPBufferSize
+	case "tcp":
+		size = StatsdTCPBufferSize
+	case "unixgram":
+		size = StatsdUnixBufferSize
+	default:
+		return nil, fmt.Errorf("statsd: unsupported network: %s", network)
+	}
+
+	if !buffered {
+		size = 0
+	}
+
+	return &StatsdConn{
+		w: &statsdWriter{
+			network: network,
+			addr:    addr,
+			size:    size,
+			dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, 5*time.Second)
+			},
+		},
+	}, nil
+}
+
+// WithTags returns a copy of the StatsdConn that also sends tags, and shares
+// the same connection and buffer.
+func (c *StatsdConn) WithTags(tags []string) StatsdClient {
+	return &StatsdConn{
+		w:    c.w,
+		tags: append(append([]string{}, c.tags...), tags...),
+	}
+}
+
+// Flush writes any buffered metrics.
+func (c *StatsdConn) Flush() error {
+	return c.w.Flush()
+}
+
+// Close flushes any buffered metrics and closes the connection.
+func (c *StatsdConn) Close() error {
+	return c.w.Close()
+}
+
+func (c *StatsdConn) Incr(name string, value int64) error {
+	if value == 0 {
+		return nil
+	}
+	return c.send(name, fmt.Sprintf("%d|c", value))
+}
+
+func (c *StatsdConn) Gauge(name string, value int64) error {
+	return c.send(name, fmt.Sprintf("%d|g", value))
+}
+
+func (c *StatsdConn) FGauge(name string, value float64) error {
+	return c.send(name, strconv.FormatFloat(value, 'f', -1, 64)+"|g")
+}
+
+func (c *StatsdConn) GaugeDelta(name string, value int64) error {
+	return c.send(name, fmt.Sprintf("%+d|g", value))
+}
+
+func (c *StatsdConn) Timing(name string, delta int64) error {
+	return c.send(name, fmt.Sprintf("%d|ms", delta))
+}
+
+func (c *StatsdConn) PrecisionTiming(name string, delta time.Duration) error {
+	return c.send(name, fmt.Sprintf("%.6f|ms", float64(delta)/float64(time.Millisecond)))
+}
+
+func (c *StatsdConn) Absolute(name string, value int64) error {
+	return c.send(name, fmt.Sprintf("%d|a", value))
+}
+
+func (c *StatsdConn) Total(name string, value int64) error {
+	return c.send(name, fmt.Sprintf("%d|t", value))
+}
+
+func (c *StatsdConn) send(name, value string) error {
+	line := name + ":" + value
+	if len(c.tags) > 0 {
+		line += "|#" + strings.Join(c.tags, ",")
+	}
+
+	return c.w.Write(line)
+}
+
+// statsdWriter writes lines to a statsd connection.
+type statsdWriter struct {
+	network, addr string
+
+	// size is the maximum size of a single write. If 0, every line is
+	// written immediately.
+	size int
+
+	dial func(network, addr string) (net.Conn, error)
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  bytes.Buffer
+
+	// backoff is how long to wait after the last failed attempt to
+	// connect, and retry is when the next attempt can be made.
+	backoff time.Duration
+	retry   time.Time
+}
+
+// Write writes a single line, or adds it to the buffer.
+func (w *statsdWriter) Write(line string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size == 0 {
+		w.buf.WriteString(line)
+		return w.flush()
+	}
+
+	var err error
+	if w.buf.Len() > 0 && w.buf.Len()+len(line)+1 > w.size {
+		err = w.flush()
+	}
+
+	if w.buf.Len() > 0 {
+		w.buf.WriteByte('\n')
+	}
+	w.buf.WriteString(line)
+
+	return err
+}
+
+// Flush writes the buffer.
+func (w *statsdWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.flush()
+}
+
+// Close flushes the buffer and closes the connection.
+func (w *statsdWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.flush()
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+func (w *statsdWriter) flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+
+	// The buffer is reset even if the write fails, so that a statsd
+	// server that's down doesn't cause metrics to pile up.
+	defer w.buf.Reset()
+
+	// Streams need a terminator between writes.
+	if w.network == "tcp" {
+		w.buf.WriteByte('\n')
+	}
+
+	if w.conn == nil {
+		// Don't hold up every collector by dialing on every write
+		// while statsd is unreachable.
+		if time.Now().Before(w.retry) {
+			w.failed()
+			return errStatsdBackoff
+		}
+
+		conn, err := w.dial(w.network, w.addr)
+		if err != nil {
+			if w.backoff *= 2; w.backoff == 0 {
+				w.backoff = StatsdBackoff
+			}
+			if w.backoff > MaxStatsdBackoff {
+				w.backoff = MaxStatsdBackoff
+			}
+			w.retry = time.Now().Add(w.backoff)
+
+			w.failed()
+			return err
+		}
+		w.conn = conn
+		w.backoff = 0
+	}
+
+	if _, err := w.conn.Write(w.buf.Bytes()); err != nil {
+		w.failed()
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// failed counts the metrics in the buffer as send failures.
+func (w *statsdWriter) failed() {
+	n := bytes.Count(bytes.TrimSpace(w.buf.Bytes()), []byte{'\n'}) + 1
+	statsdSendFailures.Add(uint64(n))
+}
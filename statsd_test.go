@@ -0,0 +1,138 @@
+package stats
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatsdConn_Buffered(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := NewStatsdConn("udp", l.LocalAddr().String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.w.size = 64
+
+	c.Gauge("MemoryStats.Usage", 1024)
+	c.FGauge("CPUStats.Percent", 12.5)
+	c.WithTags([]string{"container_name:web"}).Incr("Network.RxBytes", 50)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []string
+	b := make([]byte, 1024)
+	for len(packets) < 2 {
+		l.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := l.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, string(b[:n]))
+	}
+
+	want := []string{
+		"MemoryStats.Usage:1024|g\nCPUStats.Percent:12.5|g",
+		"Network.RxBytes:50|c|#container_name:web",
+	}
+	for i := range want {
+		if packets[i] != want[i] {
+			t.Errorf("packet #%d => %q; want %q", i, packets[i], want[i])
+		}
+	}
+}
+
+func TestStatsdConn_Backoff(t *testing.T) {
+	c, err := NewStatsdConn("tcp", "127.0.0.1:0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dials int
+	c.w.dial = func(network, addr string) (net.Conn, error) {
+		dials++
+		return nil, errors.New("connection refused")
+	}
+
+	failures := atomic.LoadUint64(&statsdSendFailures.value)
+
+	// Only the first write dials, and the rest are dropped while backing
+	// off.
+	for i := 0; i < 3; i++ {
+		if err := c.Gauge("MemoryStats.Usage", 1024); err == nil {
+			t.Error("Gauge() => nil; want an error")
+		}
+	}
+
+	if got, want := dials, 1; got != want {
+		t.Errorf("dials => %d; want %d", got, want)
+	}
+	if got, want := atomic.LoadUint64(&statsdSendFailures.value)-failures, uint64(3); got != want {
+		t.Errorf("failures => %d; want %d", got, want)
+	}
+
+	// Once the backoff has elapsed, it dials again, and waits twice as
+	// long after failing.
+	c.w.retry = time.Now()
+	c.Gauge("MemoryStats.Usage", 1024)
+	if got, want := dials, 2; got != want {
+		t.Errorf("dials => %d; want %d", got, want)
+	}
+	if got, want := c.w.backoff, 2*StatsdBackoff; got != want {
+		t.Errorf("backoff => %s; want %s", got, want)
+	}
+}
+
+func TestStatsdConn_TCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	c, err := NewStatsdConn("tcp", addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go c.Gauge("MemoryStats.Usage", 1024)
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1024)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b[:n]), "MemoryStats.Usage:1024|g\n"; got != want {
+		t.Errorf("Gauge() => %q; want %q", got, want)
+	}
+	conn.Close()
+	l.Close()
+
+	// Once the server is gone, sends should fail and be counted.
+	before := atomic.LoadUint64(&statsdSendFailures.value)
+	var failed bool
+	for i := 0; i < 10 && !failed; i++ {
+		err = c.Gauge("MemoryStats.Usage", 1024)
+		failed = err != nil && !strings.Contains(err.Error(), "timeout")
+	}
+	if !failed {
+		t.Fatal("expected a send to fail")
+	}
+	if atomic.LoadUint64(&statsdSendFailures.value) <= before {
+		t.Error("expected send failures to be counted")
+	}
+}
@@ -0,0 +1,215 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// templateRenderFailures counts the metrics whose name couldn't be rendered
+// with the configured template, and were named with the default template of
+// the adapter instead.
+var templateRenderFailures = newInternalCounter("Internal.Template.RenderFailures")
+
+// syntheticContainer is the container that templates are validated against
+// when they're parsed. Like the containers that docker inspects, it has all of
+// the fields that are pointers or maps set, so that templates that use them,
+// like `{{.Container.NetworkSettings.IPAddress}}`, are valid.
+var syntheticContainer = &docker.Container{
+	ID:    "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	Name:  "/dockerstats-template-check",
+	Image: "0123456789abcdef",
+	Config: &docker.Config{
+		Hostname:     "0123456789ab",
+		Image:        "remind101/dockerstats:latest",
+		Env:          []string{},
+		Labels:       map[string]string{},
+		ExposedPorts: map[docker.Port]struct{}{},
+		Volumes:      map[string]struct{}{},
+	},
+	State: docker.State{
+		Running: true,
+		Pid:     1,
+	},
+	NetworkSettings: &docker.NetworkSettings{
+		IPAddress:   "172.17.0.2",
+		IPPrefixLen: 16,
+		Gateway:     "172.17.0.1",
+		Bridge:      "docker0",
+		PortMapping: map[string]docker.PortMapping{},
+		Ports:       map[docker.Port][]docker.PortBinding{},
+	},
+	HostConfig: &docker.HostConfig{
+		NetworkMode:   "bridge",
+		PortBindings:  map[docker.Port][]docker.PortBinding{},
+		RestartPolicy: docker.NeverRestart(),
+		LogConfig:     docker.LogConfig{Type: "json-file", Config: map[string]string{}},
+	},
+	Volumes:   map[string]string{},
+	VolumesRW: map[string]bool{},
+}
+
+// safeTemplate is a template that falls back to rendering the default template
+// of an adapter, when it fails to render for a container, so that a template
+// that doesn't work for some containers can't stop their metrics.
+type safeTemplate struct {
+	template *template.Template
+	fallback *template.Template
+
+	// label is the container label that containers can set to render
+	// their metrics with a different template. The default is
+	// TemplateLabel.
+	label string
+	name  string
+	funcs template.FuncMap
+
+	mu sync.Mutex
+	// overrides holds the parsed templates from the label, or nil if the
+	// template in the label couldn't be parsed.
+	overrides map[string]*template.Template
+}
+
+// newSafeTemplate parses tmpl, and the fallback template def, with the
+// template functions. The template is validated by rendering it for a
+// synthetic container, so that templates that can't be rendered at all are
+// reported at startup.
+func newSafeTemplate(name, tmpl, def string, sanitizer func() Sanitizer) (*safeTemplate, error) {
+	funcs := templateFuncs(sanitizer)
+
+	parse := func(tmpl string) (*template.Template, error) {
+		t, err := template.New(name).Funcs(funcs).Parse(tmpl)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := renderTemplate(t, stat{Container: syntheticContainer, Type: Gauge.String(), Name: "MemoryStats.Usage"}); err != nil {
+			return nil, err
+		}
+
+		return t, nil
+	}
+
+	t, err := parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback, err := parse(def)
+	if err != nil {
+		return nil, err
+	}
+
+	return &safeTemplate{
+		template:  t,
+		fallback:  fallback,
+		label:     TemplateLabel,
+		name:      name,
+		funcs:     funcs,
+		overrides: make(map[string]*template.Template),
+	}, nil
+}
+
+// render renders the template, or the template in the label of the container
+// if it sets one. If it fails, the failure is counted and the fallback
+// template is rendered instead. If that fails too, the name of the metric is
+// returned.
+func (t *safeTemplate) render(data stat) string {
+	tmpl := t.template
+	if o := t.override(data.Container); o != nil {
+		tmpl = o
+	}
+
+	v, err := renderTemplate(tmpl, data)
+	if err == nil {
+		return v
+	}
+
+	templateRenderFailures.Incr()
+
+	if v, err := renderTemplate(t.fallback, data); err == nil {
+		return v
+	}
+
+	return data.Name
+}
+
+// override returns the template that the container sets with its label, if
+// any. Templates that can't be parsed are counted as render failures and
+// ignored.
+func (t *safeTemplate) override(c *docker.Container) *template.Template {
+	if t.label == "" || c == nil || c.Config == nil {
+		return nil
+	}
+
+	tmpl, ok := c.Config.Labels[t.label]
+	if !ok {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if o, ok := t.overrides[tmpl]; ok {
+		return o
+	}
+
+	o, err := template.New(t.name).Funcs(t.funcs).Parse(tmpl)
+	if err != nil {
+		debug("%s: invalid %s label: %v", c.Name, t.label, err)
+		templateRenderFailures.Incr()
+		o = nil
+	}
+	t.overrides[tmpl] = o
+
+	return o
+}
+
+// renderTemplate executes the template with the data.
+func renderTemplate(t *template.Template, data stat) (string, error) {
+	b := new(bytes.Buffer)
+	if err := t.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// templateFuncs returns the functions that are available to the templates of
+// the adapters:
+//
+//	lower        Lower cases a string, e.g. {{.Name | lower}}.
+//	replace      Replaces all occurrences of a string, e.g. {{.Name | replace "." "_"}}.
+//	sanitize     Sanitizes a single piece of the name for the output format of
+//	             the adapter, including dots, e.g. {{sanitize .Container.Name}}.
+//	default      Returns the first argument if the piped value is empty, e.g.
+//	             {{.Label "com.docker.compose.service" | default .ShortName}}.
+//	trimPrefix   Removes a prefix, e.g. {{.Image | trimPrefix "quay.io/"}}.
+//	split        Splits a string into a list, e.g. {{index (split "/" .Image) 0}}.
+//
+// The Sanitizer is looked up when the template is executed, so that it can be
+// changed after the template is parsed.
+func templateFuncs(sanitizer func() Sanitizer) template.FuncMap {
+	return template.FuncMap{
+		"lower": strings.ToLower,
+		"replace": func(old, new, s string) string {
+			return strings.Replace(s, old, new, -1)
+		},
+		"sanitize": func(v string) string {
+			return sanitizer().SanitizeComponent(v)
+		},
+		"default": func(def, v string) string {
+			if v == "" {
+				return def
+			}
+			return v
+		},
+		"trimPrefix": func(prefix, s string) string {
+			return strings.TrimPrefix(s, prefix)
+		},
+		"split": func(sep, s string) []string {
+			return strings.Split(s, sep)
+		},
+	}
+}
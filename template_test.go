@@ -0,0 +1,147 @@
+package stats
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	c := &docker.Container{
+		ID:   "abcdef0123456789",
+		Name: "/web.1",
+		Config: &docker.Config{
+			Image:  "registry.example.com:5000/remind101/acme-inc:v1.2",
+			Labels: map[string]string{"com.docker.compose.service": "web"},
+		},
+	}
+
+	tests := []struct {
+		template string
+		out      string
+	}{
+		{`{{.Label "com.docker.compose.service" | default .ShortName}}`, "web"},
+		{`{{.Label "missing" | default .ShortName}}`, "web.1"},
+		{`{{.ImageTag}}`, "v1.2"},
+		{`{{.Image | trimPrefix "registry.example.com:5000/"}}`, "remind101/acme-inc:v1.2"},
+		{`{{index (split "/" .Image) 1}}`, "remind101"},
+		{`{{.Name | lower | replace "." "_"}}`, "memorystats_usage"},
+		{`{{sanitize .ShortName}}`, "web_1"},
+	}
+
+	for _, tt := range tests {
+		b := new(bytes.Buffer)
+		a, err := NewLogAdapter(tt.template, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.Sample(c, "MemoryStats.Usage", 1)
+		if got, want := b.String(), tt.out+"\n"; got != want {
+			t.Errorf("%s => %q; want %q", tt.template, got, want)
+		}
+	}
+}
+
+func TestStat_ImageTag(t *testing.T) {
+	tests := []struct {
+		image string
+		tag   string
+	}{
+		{"remind101/acme-inc", "latest"},
+		{"remind101/acme-inc:v1", "v1"},
+		{"localhost:5000/acme-inc", "latest"},
+		{"localhost:5000/acme-inc:v1", "v1"},
+		{"acme-inc:v1@sha256:abcd", "v1"},
+	}
+
+	for _, tt := range tests {
+		s := stat{Container: &docker.Container{Config: &docker.Config{Image: tt.image}}}
+		if got := s.ImageTag(); got != tt.tag {
+			t.Errorf("ImageTag(%q) => %q; want %q", tt.image, got, tt.tag)
+		}
+	}
+}
+
+func TestSafeTemplate(t *testing.T) {
+	tmpl, err := newSafeTemplate("stat", `{{.Container.Config.Image}}.{{.Name}}`, `{{.Name}}.source__{{.Container.Name}}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := atomic.LoadUint64(&templateRenderFailures.value)
+
+	c := &docker.Container{Name: "web", Config: &docker.Config{Image: "acme-inc"}}
+	if got, want := tmpl.render(stat{Container: c, Name: "MemoryStats.Usage"}), "acme-inc.MemoryStats.Usage"; got != want {
+		t.Errorf("render() => %q; want %q", got, want)
+	}
+
+	// A container without a Config can't be rendered with the template, so
+	// the fallback template is used.
+	c = &docker.Container{Name: "web"}
+	if got, want := tmpl.render(stat{Container: c, Name: "MemoryStats.Usage"}), "MemoryStats.Usage.source__web"; got != want {
+		t.Errorf("render() => %q; want %q", got, want)
+	}
+
+	if got, want := atomic.LoadUint64(&templateRenderFailures.value)-before, uint64(1); got != want {
+		t.Errorf("failures => %d; want %d", got, want)
+	}
+}
+
+func TestSafeTemplate_ContainerFields(t *testing.T) {
+	// The fields that docker always sets when it inspects a container can
+	// be used in templates.
+	for _, tmpl := range []string{
+		`{{.Container.NetworkSettings.IPAddress}}.{{.Name}}`,
+		`{{.Container.HostConfig.NetworkMode}}.{{.Name}}`,
+		`{{.Container.HostConfig.RestartPolicy.Name}}.{{.Name}}`,
+		`{{if .Container.State.Running}}running{{end}}.{{.Name}}`,
+		`{{index .Container.Config.Labels "com.docker.compose.service"}}.{{.Name}}`,
+		`{{range $port, $_ := .Container.NetworkSettings.Ports}}{{$port}}{{end}}.{{.Name}}`,
+	} {
+		if _, err := newSafeTemplate("stat", tmpl, StatsdTemplate, nil); err != nil {
+			t.Errorf("newSafeTemplate(%q) => %v; want nil", tmpl, err)
+		}
+	}
+}
+
+func TestSafeTemplate_Invalid(t *testing.T) {
+	for _, tmpl := range []string{
+		`{{.Name`,
+		`{{.Container.Bogus}}`,
+		`{{.Label}}`,
+	} {
+		if _, err := newSafeTemplate("stat", tmpl, StatsdTemplate, nil); err == nil {
+			t.Errorf("newSafeTemplate(%q) => nil; want an error", tmpl)
+		}
+	}
+}
+
+func TestSafeTemplate_Override(t *testing.T) {
+	tmpl, err := newSafeTemplate("stat", `{{.Name}}`, StatsdTemplate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := atomic.LoadUint64(&templateRenderFailures.value)
+
+	for _, tt := range []struct {
+		labels map[string]string
+		out    string
+	}{
+		{nil, "MemoryStats.Usage"},
+		{map[string]string{TemplateLabel: `web.{{.Name}}`}, "web.MemoryStats.Usage"},
+		{map[string]string{TemplateLabel: `{{.Name`}, "MemoryStats.Usage"},
+	} {
+		c := &docker.Container{Name: "web", Config: &docker.Config{Labels: tt.labels}}
+		if got := tmpl.render(stat{Container: c, Name: "MemoryStats.Usage"}); got != tt.out {
+			t.Errorf("render(%v) => %q; want %q", tt.labels, got, tt.out)
+		}
+	}
+
+	if got, want := atomic.LoadUint64(&templateRenderFailures.value)-before, uint64(1); got != want {
+		t.Errorf("failures => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package stats
+
+import "github.com/fsouza/go-dockerclient"
+
+// aggregate holds the aggregated values of a single gauge.
+type aggregate struct {
+	min, max, sum, last float64
+	count               int
+}
+
+// window aggregates the gauges of all of the stats received for a container
+// within a resolution window.
+type window struct {
+	gauges map[string]*aggregate
+}
+
+func newWindow() *window {
+	return &window{gauges: make(map[string]*aggregate)}
+}
+
+// add adds the gauges in stats to the window.
+func (w *window) add(stats *docker.Stats) {
+	walk(stats, func(m Metric) {
+		if m.Kind == Gauge {
+			w.observe(m.Name, m.Value)
+		}
+	})
+}
+
+// observe adds a single value of the named gauge to the window.
+func (w *window) observe(name string, value float64) {
+	a, ok := w.gauges[name]
+	if !ok {
+		a = &aggregate{min: value, max: value}
+		w.gauges[name] = a
+	}
+
+	if value < a.min {
+		a.min = value
+	}
+	if value > a.max {
+		a.max = value
+	}
+	a.last = value
+	a.sum += value
+	a.count++
+}
+
+// each calls fn with a copy of m for each of the min, max, mean and last value
+// of the gauge within the window.
+func (w *window) each(m Metric, fn func(Metric)) {
+	a, ok := w.gauges[m.Name]
+	if !ok {
+		return
+	}
+
+	emit := func(suffix string, value float64) {
+		agg := m.derive(suffix)
+		agg.Value = value
+		fn(agg)
+	}
+
+	emit("min", a.min)
+	emit("max", a.max)
+	emit("mean", a.sum/float64(a.count))
+	emit("last", a.last)
+}
+
+// reset clears the window.
+func (w *window) reset() {
+	w.gauges = make(map[string]*aggregate)
+}
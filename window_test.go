@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestWindow(t *testing.T) {
+	w := newWindow()
+
+	for _, usage := range []uint64{10, 40, 20, 30} {
+		stats := new(docker.Stats)
+		stats.MemoryStats.Usage = usage
+		stats.Network.RxBytes = usage
+		w.add(stats)
+	}
+
+	got := make(map[string]float64)
+	each := func(name string) {
+		w.each(Metric{Name: name}, func(m Metric) {
+			got[m.Name] = m.Value
+		})
+	}
+	each("MemoryStats.Usage")
+	each("Network.RxBytes")
+
+	want := map[string]float64{
+		"MemoryStats.Usage.min":  10,
+		"MemoryStats.Usage.max":  40,
+		"MemoryStats.Usage.mean": 25,
+		"MemoryStats.Usage.last": 30,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("each() => %v; want %v", got, want)
+	}
+
+	w.reset()
+	if len(w.gauges) != 0 {
+		t.Errorf("reset() left %d gauges", len(w.gauges))
+	}
+}